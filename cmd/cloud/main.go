@@ -1,50 +1,181 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/felixreverett/wildfawn/fawnbot"
 )
 
+const (
+	programConfigPath = "configs/programConfig.json"
+	runHistoryPath    = "configs/runHistory.json"
+)
+
 func main() {
+	daemon := flag.Bool("daemon", false, "keep running, crawling sites whenever they fall due")
+	poll := flag.Duration("poll", 15*time.Minute, "(daemon) how often to reload crawl configs and check for due sites")
+	maxSites := flag.Int("max-sites", 1, "maximum number of sites crawled at once")
+	fromDisk := flag.Bool("disk", false, "load crawl configs from configs/*CrawlConfig.json instead of the control sheet")
+	flag.Parse()
+
 	// a. Load program config
 	fmt.Println("= = = Initialising Wildfawn Cloud = = =")
-	programConfig, err := fawnbot.LoadProgramConfig("configs/programConfig.json")
+	programConfig, err := fawnbot.LoadProgramConfig(programConfigPath)
 	if err != nil {
 		fmt.Println("[!] Error loading program config. Using default:", err)
 	} else {
 		fmt.Println("(i) Successfully loaded program config")
 	}
 
-	// b. Load crawl configs
-	//crawlConfigs, err := fawnbot.LoadCrawlConfigs()
-	crawlConfigs, err := fawnbot.FetchCrawlConfigsFromSheet(programConfig.ReadSheetID, programConfig.ReadSheetName)
+	// b. Load run history, so missed crawls catch up
+	history, err := fawnbot.LoadRunHistory(runHistoryPath)
+	if err != nil {
+		fmt.Println("[!] Error loading run history. Treating all sites as never run:", err)
+	}
+
+	// c. Stop gracefully on SIGTERM/SIGINT. In-flight crawls are checkpointed and resume on their next run
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	s := &scheduler{programConfig: programConfig, history: history, fromDisk: *fromDisk, running: make(map[string]bool), slots: make(chan struct{}, max(*maxSites, 1)), linkCache: fawnbot.NewLinkCache()}
+
+	if !*daemon {
+		s.runDueSites(ctx)
+		s.wait()
+		return
+	}
+
+	fmt.Printf("(i) Running as daemon. Checking for due sites every %s, crawling up to %d at once\n", *poll, cap(s.slots))
+	ticker := time.NewTicker(*poll)
+	defer ticker.Stop()
+
+	for {
+		s.runDueSites(ctx)
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("(i) Shutting down. Waiting for in-flight crawls to checkpoint...")
+			s.wait()
+			fmt.Println("(i) Wildfawn Cloud stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type scheduler struct {
+	programConfig fawnbot.ProgramConfig
+	history       *fawnbot.RunHistory
+	fromDisk      bool
+	linkCache     *fawnbot.LinkCache // external link checks, shared by every site crawled in the run
+
+	mu      sync.Mutex
+	running map[string]bool // run keys of sites currently queued or crawling
+	slots   chan struct{}   // limits how many sites crawl at once
+	wg      sync.WaitGroup
+}
+
+// (re)loads crawl configs and starts every due site that isn't already running
+func (s *scheduler) runDueSites(ctx context.Context) {
+	crawlConfigs, err := s.loadCrawlConfigs()
 	if err != nil {
 		fmt.Println("[!] Error loading crawl configs:", err)
+		return
 	}
 
-	// c. Crawl and export all
 	for _, crawlConfig := range crawlConfigs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if retryAt := s.history.RetryAt(crawlConfig); time.Now().Before(retryAt) {
+			fmt.Printf("(i) Site %s failed its last run. Retrying after %s\n", crawlConfig.Root, retryAt.Format(time.RFC3339))
+			continue
+		}
 
-		ok, err := fawnbot.IsSiteDue(crawlConfig)
+		ok, err := fawnbot.IsSiteDue(crawlConfig, s.history.LastSuccess(crawlConfig))
 		if err != nil {
 			fmt.Println("[!] Error determining if site is due:", err)
 			continue
 		}
+		if !ok {
+			fmt.Printf("(i) Site %s is not due\n", crawlConfig.Root)
+			continue
+		}
 
-		if ok {
-			fmt.Printf("(i) Site %s is due. Crawling\n", crawlConfig.Root)
-			URLObjectList, err := fawnbot.GoWild(crawlConfig, programConfig)
-			if err != nil {
-				fmt.Println("[!] Error crawling root URL, aborting:", err)
-				continue
-			}
-			analysis := fawnbot.AnalyseCrawl(URLObjectList)
+		s.mu.Lock()
+		if s.running[crawlConfig.RunKey()] {
+			s.mu.Unlock()
+			fmt.Printf("(i) Site %s is due but still running from a previous check\n", crawlConfig.Root)
+			continue
+		}
+		s.running[crawlConfig.RunKey()] = true
+		s.mu.Unlock()
 
-			fawnbot.WriteWild(URLObjectList, analysis, crawlConfig)
-		} else {
-			fmt.Printf("(i) Site %s is not due\n", crawlConfig.Root)
+		s.wg.Add(1)
+		go s.runSite(ctx, crawlConfig)
+	}
+}
+
+func (s *scheduler) loadCrawlConfigs() ([]fawnbot.CrawlConfig, error) {
+	if s.fromDisk {
+		return fawnbot.LoadCrawlConfigs()
+	}
+	return fawnbot.FetchCrawlConfigsFromSheet(s.programConfig.ReadSheetID, s.programConfig.ReadSheetName)
+}
+
+// crawls and exports a single site once a slot is free, then records the run
+func (s *scheduler) runSite(ctx context.Context, crawlConfig fawnbot.CrawlConfig) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, crawlConfig.RunKey())
+		s.mu.Unlock()
+	}()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	fmt.Printf("(i) Site %s is due. Crawling\n", crawlConfig.Root)
+	started := time.Now()
+	URLObjectList, err := fawnbot.GoWildContext(ctx, crawlConfig, s.programConfig, fawnbot.WithLinkCache(s.linkCache))
+	if err != nil {
+		if ctx.Err() != nil {
+			return // interrupted, so neither a success nor a failure
 		}
+		fmt.Println("[!] Error crawling root URL, aborting:", err)
+	} else {
+		// the run only succeeds if the crawl is analysed and exported too
+		var errs []error
+		if analysis, analyseErr := fawnbot.AnalyseCrawl(URLObjectList); analyseErr != nil {
+			fmt.Println("[!] Error analysing crawl:", analyseErr)
+			errs = append(errs, fmt.Errorf("failed to analyse crawl: %v", analyseErr))
+		} else if exportErr := fawnbot.WriteWild(URLObjectList, analysis, crawlConfig); exportErr != nil {
+			errs = append(errs, fmt.Errorf("failed to export crawl: %v", exportErr))
+		}
+		err = errors.Join(errs...)
+		if err := URLObjectList.Close(); err != nil {
+			fmt.Println("[!] Error closing crawl store:", err)
+		}
+	}
 
+	s.history.RecordRun(crawlConfig, started, err)
+	if err := s.history.Save(); err != nil {
+		fmt.Println("[!] Error saving run history:", err)
 	}
 }
+
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
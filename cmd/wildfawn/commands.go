@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/felixreverett/wildfawn/fawnbot"
+)
+
+var stderrLogger = log.New(os.Stderr, "", 0)
+
+func fail(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "[!] "+format+"\n", args...)
+	return exitError
+}
+
+func runCrawl(args []string) int {
+	fs := newFlagSet("crawl", "<url>", "Crawls a site and writes the results. Settings come from the defaults, then -config, then any setting flags.")
+	configPath := fs.String("config", "", "program config JSON to start from")
+	format := fs.String("format", "json", "output format: json, csv or summary")
+	output := fs.String("o", "", "output file (default stdout)")
+	crawlID := fs.String("crawl-id", "", "resume, or start, the crawl with this ID (default: one per site)")
+	scopePath := fs.String("scope", "", "crawl scope JSON: Hosts, IncludeSubdomains, IncludePaths, ExcludePaths, ExcludeParams (default: the root's host)")
+	requestsPath := fs.String("requests", "", "request settings JSON: Headers, Cookies, CookieJar, Auth, Login (credentials come from -secrets-file or the environment)")
+	settings := bindProgramConfigFlags(fs)
+
+	positional, code := parseArgs(fs, args, 1)
+	if code >= 0 {
+		return code
+	}
+	if *format != "json" && *format != "csv" && *format != "summary" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown format '%s'\n", *format)
+		return exitUsage
+	}
+
+	config := fawnbot.DefaultProgramConfig()
+	if *configPath != "" {
+		loaded, err := fawnbot.LoadProgramConfig(*configPath)
+		if err != nil {
+			return fail("%v", err)
+		}
+		config = loaded
+	}
+	settings.apply(fs, &config)
+
+	crawlConfig := fawnbot.CrawlConfig{Root: positional[0]}
+	if *scopePath != "" {
+		data, err := os.ReadFile(*scopePath)
+		if err != nil {
+			return fail("Failed to read scope: %v", err)
+		}
+		if err := json.Unmarshal(data, &crawlConfig.Scope); err != nil {
+			return fail("Failed to parse scope: %v", err)
+		}
+	}
+	if *requestsPath != "" {
+		data, err := os.ReadFile(*requestsPath)
+		if err != nil {
+			return fail("Failed to read request settings: %v", err)
+		}
+		if err := json.Unmarshal(data, &crawlConfig.Requests); err != nil {
+			return fail("Failed to parse request settings: %v", err)
+		}
+	}
+
+	// Ctrl-C stops the crawl, checkpointing it so the same command (or -crawl-id) resumes it
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	crawler := fawnbot.NewCrawler(crawlConfig, fawnbot.WithProgramConfig(config), fawnbot.WithLogger(stderrLogger), fawnbot.WithCrawlID(*crawlID))
+	result, err := crawler.Run(ctx)
+	if result != nil {
+		defer result.Close()
+	}
+	if err != nil {
+		return fail("Crawl failed: %v", err)
+	}
+	list := result.URLObjectList
+
+	out, err := openOutput(*output)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer out.Close()
+
+	switch *format {
+	case "csv":
+		err = fawnbot.WriteCrawlCSV(out, list)
+	case "summary":
+		err = printAnalysis(out, result.Analysis)
+	default:
+		err = fawnbot.WriteCrawlJSON(out, list)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	return exitOK
+}
+
+func runAnalyse(args []string) int {
+	fs := newFlagSet("analyse", "<crawl-file>", "Prints the analysis of a crawl saved with 'crawl -format json' ('-' reads stdin).")
+	format := fs.String("format", "text", "output format: text or json")
+
+	positional, code := parseArgs(fs, args, 1)
+	if code >= 0 {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown format '%s'\n", *format)
+		return exitUsage
+	}
+
+	list, err := readCrawlFile(positional[0])
+	if err != nil {
+		return fail("%v", err)
+	}
+	analysis, err := fawnbot.AnalyseCrawl(list)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if *format == "json" {
+		err = writeJSON(os.Stdout, analysis)
+	} else {
+		err = printAnalysis(os.Stdout, analysis)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	return exitOK
+}
+
+func runExport(args []string) int {
+	fs := newFlagSet("export", "<crawl-file>", "Exports a saved crawl. 'sheets' writes the crawl and analysis to the spreadsheet named in a crawl config.")
+	format := fs.String("format", "sheets", "export format: sheets, csv, json, hreflang, images, resources, external or outofscope (CSVs of hreflang annotations, images, resources, external links and out-of-scope URLs)")
+	crawlConfigPath := fs.String("config", "", "crawl config JSON naming the spreadsheet and sheets (for -format sheets)")
+	output := fs.String("o", "", "output file for csv and json (default stdout)")
+
+	positional, code := parseArgs(fs, args, 1)
+	if code >= 0 {
+		return code
+	}
+
+	list, err := readCrawlFile(positional[0])
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	switch *format {
+	case "sheets":
+		if *crawlConfigPath == "" {
+			fmt.Fprintln(os.Stderr, "[!] -config is required for Sheets exports")
+			return exitUsage
+		}
+		crawlConfig, err := fawnbot.LoadCrawlConfig(*crawlConfigPath)
+		if err != nil {
+			return fail("%v", err)
+		}
+		analysis, err := fawnbot.AnalyseCrawl(list)
+		if err != nil {
+			return fail("%v", err)
+		}
+		exporter := fawnbot.SheetsExporter{CrawlConfig: crawlConfig, Logger: stderrLogger}
+		if err := exporter.Export(context.Background(), &fawnbot.CrawlResult{URLObjectList: list, Analysis: analysis}); err != nil {
+			return fail("Export failed: %v", err)
+		}
+		return exitOK
+	case "csv", "json", "hreflang", "images", "resources", "external", "outofscope":
+		out, err := openOutput(*output)
+		if err != nil {
+			return fail("%v", err)
+		}
+		defer out.Close()
+
+		switch *format {
+		case "csv":
+			err = fawnbot.WriteCrawlCSV(out, list)
+		case "json":
+			err = fawnbot.WriteCrawlJSON(out, list)
+		case "hreflang":
+			err = fawnbot.WriteHreflangCSV(out, list)
+		case "images":
+			err = fawnbot.WriteImagesCSV(out, list)
+		case "resources":
+			err = fawnbot.WriteResourcesCSV(out, list)
+		case "external":
+			err = fawnbot.WriteExternalLinksCSV(out, list)
+		case "outofscope":
+			err = fawnbot.WriteOutOfScopeCSV(out, list)
+		}
+		if err != nil {
+			return fail("%v", err)
+		}
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "[!] Unknown format '%s'\n", *format)
+		return exitUsage
+	}
+}
+
+func runDiff(args []string) int {
+	fs := newFlagSet("diff", "<a> <b>", "Compares two saved crawls. Exits 1 if they differ.")
+	format := fs.String("format", "text", "output format: text or json")
+
+	positional, code := parseArgs(fs, args, 2)
+	if code >= 0 {
+		return code
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown format '%s'\n", *format)
+		return exitUsage
+	}
+
+	before, err := readCrawlFile(positional[0])
+	if err != nil {
+		return fail("%s: %v", positional[0], err)
+	}
+	after, err := readCrawlFile(positional[1])
+	if err != nil {
+		return fail("%s: %v", positional[1], err)
+	}
+
+	diff := fawnbot.DiffCrawls(before, after)
+
+	if *format == "json" {
+		if err := writeJSON(os.Stdout, diff); err != nil {
+			return fail("%v", err)
+		}
+	} else {
+		for _, url := range diff.Added {
+			fmt.Printf("+ %s\n", url)
+		}
+		for _, url := range diff.Removed {
+			fmt.Printf("- %s\n", url)
+		}
+		for _, change := range diff.Changed {
+			fmt.Printf("~ %s %s: %v -> %v\n", change.URL, change.Field, change.Before, change.After)
+		}
+		fmt.Fprintf(os.Stderr, "(i) %d added, %d removed, %d changes\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	}
+
+	if !diff.IsEmpty() {
+		return exitNegative
+	}
+	return exitOK
+}
+
+func runRobots(args []string) int {
+	fs := newFlagSet("robots", "test <url>", "Checks the site's robots.txt to see whether fawnbot may crawl a URL. Exits 1 if blocked.")
+
+	positional, code := parseArgs(fs, args, 2)
+	if code >= 0 {
+		return code
+	}
+	if positional[0] != "test" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown robots command '%s'\n", positional[0])
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := fawnbot.TestRobots(ctx, positional[1])
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if !result.Found {
+		fmt.Printf("allowed: no robots file at %s\n", result.RobotsURL)
+		return exitOK
+	}
+
+	verdict := "allowed"
+	if result.Blocked {
+		verdict = "blocked"
+	}
+	if result.Rule != "" {
+		fmt.Printf("%s: %s (user-agent: %s)\n", verdict, result.Rule, result.Agent)
+	} else {
+		fmt.Printf("%s: no matching rule\n", verdict)
+	}
+	if result.CrawlDelay > 0 {
+		fmt.Printf("crawl-delay: %g\n", result.CrawlDelay)
+	}
+	for _, sitemap := range result.Sitemaps {
+		fmt.Printf("sitemap: %s\n", sitemap)
+	}
+
+	if result.Blocked {
+		return exitNegative
+	}
+	return exitOK
+}
+
+func runSitemap(args []string) int {
+	fs := newFlagSet("sitemap", "fetch <url>", "Lists the URLs in a sitemap, or in a site's sitemaps found from its robots.txt.")
+	format := fs.String("format", "text", "output format: text, csv or json")
+	output := fs.String("o", "", "output file (default stdout)")
+
+	positional, code := parseArgs(fs, args, 2)
+	if code >= 0 {
+		return code
+	}
+	if positional[0] != "fetch" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown sitemap command '%s'\n", positional[0])
+		return exitUsage
+	}
+	if *format != "text" && *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "[!] Unknown format '%s'\n", *format)
+		return exitUsage
+	}
+
+	entries, err := fawnbot.FetchSitemaps(context.Background(), nil, stderrLogger, positional[1])
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	out, err := openOutput(*output)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer out.Close()
+
+	switch *format {
+	case "json":
+		err = writeJSON(out, entries)
+	case "csv":
+		writer := csv.NewWriter(out)
+		writer.Write([]string{"URL", "Last Modified", "Change Frequency", "Priority", "Sitemap"})
+		for _, entry := range entries {
+			writer.Write([]string{entry.Loc, entry.LastMod, entry.ChangeFreq, entry.Priority, entry.Sitemap})
+		}
+		writer.Flush()
+		err = writer.Error()
+	default:
+		for _, entry := range entries {
+			if _, err = fmt.Fprintln(out, entry.Loc); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "(i) %d URLs\n", len(entries))
+	return exitOK
+}
+
+func runConfig(args []string) int {
+	fs := newFlagSet("config", "validate [crawl-config-files...]", "Validates the program config and crawl configs (configs/*CrawlConfig.json by default). Exits 1 if any are invalid.")
+	programConfigPath := fs.String("program", "configs/programConfig.json", "program config to validate")
+	fromSheet := fs.Bool("sheet", false, "validate the control sheet named in the program config instead of crawl config files")
+
+	positional, code := parseArgs(fs, args, -1)
+	if code >= 0 {
+		return code
+	}
+	if len(positional) == 0 || positional[0] != "validate" {
+		fs.Usage()
+		return exitUsage
+	}
+	files := positional[1:]
+
+	invalid := 0
+	report := func(name string, err error) {
+		if err != nil {
+			invalid++
+			fmt.Printf("✗ %s: %v\n", name, err)
+		} else {
+			fmt.Printf("✓ %s\n", name)
+		}
+	}
+
+	var programConfig fawnbot.ProgramConfig
+	report(*programConfigPath, decodeStrict(*programConfigPath, &programConfig))
+
+	if *fromSheet {
+		rows, err := fawnbot.ReadControlSheet(programConfig.ReadSheetID, programConfig.ReadSheetName, stderrLogger)
+		if err != nil {
+			return fail("Could not read control sheet: %v", err)
+		}
+		for _, row := range rows {
+			if !row.Blank {
+				report(fmt.Sprintf("%s row %d (%s)", programConfig.ReadSheetName, row.Row, row.Config.Root), row.Err)
+			}
+		}
+	} else {
+		if len(files) == 0 {
+			matches, err := filepath.Glob("configs/*CrawlConfig.json")
+			if err != nil {
+				return fail("%v", err)
+			}
+			files = matches
+		}
+		for _, file := range files {
+			var crawlConfig fawnbot.CrawlConfig
+			err := decodeStrict(file, &crawlConfig)
+			if err == nil {
+				err = fawnbot.ValidateCrawlConfig(crawlConfig)
+			}
+			report(file, err)
+		}
+	}
+
+	if invalid > 0 {
+		return exitNegative
+	}
+	return exitOK
+}
+
+// decodes a JSON file, rejecting unknown fields so typos don't go unnoticed
+func decodeStrict(path string, target interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("failed to parse JSON: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// prints every analysis metric as "Name: value"
+func printAnalysis(w io.Writer, analysis fawnbot.CrawlAnalysis) error {
+	value := reflect.ValueOf(analysis)
+	for i := 0; i < value.NumField(); i++ {
+		name, field := value.Type().Field(i).Name, value.Field(i)
+
+		// maps and lists, such as search results and the slowest pages, get a line per item
+		if field.Kind() == reflect.Slice {
+			for j := 0; j < field.Len(); j++ {
+				if _, err := fmt.Fprintf(w, "%s[%d]: %v\n", name, j+1, field.Index(j).Interface()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			keys := field.MapKeys()
+			sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+			for _, key := range keys {
+				if _, err := fmt.Fprintf(w, "%s[%s]: %v\n", name, key, field.MapIndex(key).Interface()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: %v\n", name, field.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/felixreverett/wildfawn/fawnbot"
+)
+
+// creates a flag set for a subcommand, whose usage lists its arguments and flags
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: wildfawn %s [flags] %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parses flags that may appear before, between or after positional arguments, returning the positional arguments.
+// The second return value is an exit code if parsing failed (or -h was given), otherwise -1.
+func parseArgs(fs *flag.FlagSet, args []string, wantArgs int) ([]string, int) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK
+			}
+			return nil, exitUsage
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if wantArgs >= 0 && len(positional) != wantArgs {
+		fmt.Fprintf(os.Stderr, "[!] Expected %d argument(s), got %d\n\n", wantArgs, len(positional))
+		fs.Usage()
+		return nil, exitUsage
+	}
+
+	return positional, -1
+}
+
+// programConfigFlags adds a flag for every ProgramConfig setting, named in kebab-case (MaxCrawlDepth -> -max-crawl-depth).
+// Flags that are set override the loaded program config.
+type programConfigFlags struct {
+	values reflect.Value     // a scratch ProgramConfig the flags write into
+	fields map[string]string // flag name -> ProgramConfig field name
+}
+
+func bindProgramConfigFlags(fs *flag.FlagSet) *programConfigFlags {
+	defaults := fawnbot.DefaultProgramConfig()
+	flags := &programConfigFlags{values: reflect.ValueOf(&defaults).Elem(), fields: make(map[string]string)}
+
+	for i := 0; i < flags.values.NumField(); i++ {
+		field := flags.values.Type().Field(i)
+		name := kebabCase(field.Name)
+		usage := fmt.Sprintf("sets the program config's %s", field.Name)
+		target := flags.values.Field(i)
+
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			fs.DurationVar(target.Addr().Interface().(*time.Duration), name, time.Duration(target.Int()), usage)
+		case field.Type.Kind() == reflect.Bool:
+			fs.BoolVar(target.Addr().Interface().(*bool), name, target.Bool(), usage)
+		case field.Type.Kind() == reflect.Int:
+			fs.IntVar(target.Addr().Interface().(*int), name, int(target.Int()), usage)
+		case field.Type.Kind() == reflect.Int64:
+			fs.Int64Var(target.Addr().Interface().(*int64), name, target.Int(), usage)
+		case field.Type.Kind() == reflect.Float64:
+			fs.Float64Var(target.Addr().Interface().(*float64), name, target.Float(), usage)
+		case field.Type.Kind() == reflect.String:
+			fs.StringVar(target.Addr().Interface().(*string), name, target.String(), usage)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+			fs.Var(stringListFlag{target}, name, usage+" (comma-separated)")
+		default:
+			continue
+		}
+		flags.fields[name] = field.Name
+	}
+
+	return flags
+}
+
+// copies every flag that was set onto config
+func (f *programConfigFlags) apply(fs *flag.FlagSet, config *fawnbot.ProgramConfig) {
+	target := reflect.ValueOf(config).Elem()
+	fs.Visit(func(fl *flag.Flag) {
+		if field, ok := f.fields[fl.Name]; ok {
+			target.FieldByName(field).Set(f.values.FieldByName(field))
+		}
+	})
+}
+
+type stringListFlag struct {
+	target reflect.Value
+}
+
+func (s stringListFlag) String() string {
+	if !s.target.IsValid() {
+		return ""
+	}
+	return strings.Join(s.target.Interface().([]string), ",")
+}
+
+func (s stringListFlag) Set(value string) error {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	s.target.Set(reflect.ValueOf(items))
+	return nil
+}
+
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteRune('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// opens a file for writing, or stdout for "" and "-"
+func openOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// opens a file for reading, or stdin for "-"
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func readCrawlFile(path string) (fawnbot.URLObjectList, error) {
+	file, err := openInput(path)
+	if err != nil {
+		return fawnbot.URLObjectList{}, err
+	}
+	defer file.Close()
+	return fawnbot.ReadCrawlJSON(file)
+}
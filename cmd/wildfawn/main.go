@@ -1,5 +1,65 @@
 package main
 
+import (
+	"fmt"
+	"os"
+)
+
+// Exit codes, so scripts can tell a negative result from a failure
+const (
+	exitOK       = 0
+	exitNegative = 1 // the command ran but found a problem, e.g. a blocked URL, differing crawls or an invalid config
+	exitUsage    = 2 // bad arguments or flags
+	exitError    = 3 // the command couldn't complete, e.g. a network, file or API error
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"crawl", "crawl <url>: crawl a site and write the results", runCrawl},
+	{"analyse", "analyse <crawl-file>: summarise a saved crawl", runAnalyse},
+	{"export", "export <crawl-file>: export a saved crawl to Google Sheets, CSV or JSON", runExport},
+	{"diff", "diff <a> <b>: compare two saved crawls", runDiff},
+	{"robots", "robots test <url>: check whether fawnbot may crawl a URL", runRobots},
+	{"sitemap", "sitemap fetch <url>: list the URLs in a site's sitemaps", runSitemap},
+	{"config", "config validate [files...]: check program and crawl configs", runConfig},
+}
+
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage()
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "[!] Unknown command '%s'\n\n", args[0])
+	printUsage()
+	return exitUsage
+}
 
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "🦌 wildfawn - the fawnbot SEO crawler")
+	fmt.Fprintln(os.Stderr, "\nUsage: wildfawn <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'wildfawn <command> -h' for a command's flags.")
+	fmt.Fprintln(os.Stderr, "\nExit codes: 0 success, 1 negative result (blocked, differences, invalid config), 2 usage error, 3 failure.")
 }
@@ -5,28 +5,86 @@ package fawnbot
 | Summary analysis for aggregated metrics
 */
 
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
 type CrawlAnalysis struct {
-	TotalInternalURLs          int
-	Total200s                  int
-	Total300s                  int
-	Total400s                  int
-	Total500s                  int
-	TotalEmptyMetaTitles       int
-	TotalEmptyMetaDescriptions int
-	TotalMissingCanonicals     int
-	TotalNoIndexes             int
-	TotalNotInSitemap          int
-	TotalNonIndexableInSitemap int
-	TotalOrphans               int
+	TotalInternalURLs           int
+	Total200s                   int
+	Total300s                   int
+	Total400s                   int
+	Total500s                   int
+	TotalEmptyMetaTitles        int
+	TotalEmptyMetaDescriptions  int
+	TotalMissingCanonicals      int
+	TotalNoIndexes              int
+	TotalNotInSitemap           int
+	TotalNonIndexableInSitemap  int
+	TotalOrphans                int
+	TotalStructuredDataProblems int // pages with malformed or incomplete structured data
+	TotalMissingOGTitles        int
+	TotalMissingOGImages        int
+	TotalMissingOGURLs          int
+	TotalOGURLMismatches        int
+	TotalBrokenOGImages         int
+	TotalHreflangProblems       int // pages with invalid or unreciprocated hreflang
+	TotalImages                 int // distinct image URLs
+	TotalImagesMissingAlt       int // <img> tags without an alt attribute
+	TotalBrokenImages           int // distinct image URLs, if images were checked
+	TotalOversizedImages        int
+	TotalResources              int // distinct stylesheets, scripts, preloads and fonts, if resources were checked
+	TotalBrokenResources        int
+	TotalBlockedResources       int // blocked by robots.txt
+	TotalPagesBrokenResources   int // pages using at least one broken resource
+	TotalExternalLinks          int // distinct external URLs, if external links were checked
+	TotalBrokenExternalLinks    int
+	TotalPagesBrokenExternal    int // pages linking to at least one broken external URL
+	AverageTTFBMs               int64
+	TotalNonHTMLOnHTMLURLs      int            // URLs that look like pages, but didn't serve HTML
+	TotalOutOfScopeURLs         int            // linked to, but outside the crawl's scope
+	TotalTrapURLs               int            // URLs that look like crawler traps, whether crawled or skipped
+	SlowestPages                []RankedURL    `json:",omitempty"` // by download time, in ms
+	LargestPages                []RankedURL    `json:",omitempty"` // by decompressed size, in bytes
+	StructuredDataTypes         map[string]int `json:",omitempty"` // structured data items of each type
+	SearchResults               map[string]int `json:",omitempty"` // pages flagged by each search rule
+	Traps                       map[string]int `json:",omitempty"` // trap URLs caught by each pattern
+}
+
+// A URL and the metric it was ranked by
+type RankedURL struct {
+	URL   string
+	Value int64
 }
 
-func AnalyseCrawl(objectList URLObjectList) CrawlAnalysis {
+const rankedURLs = 10 // how many slowest and largest pages are listed
+
+// Analyses a crawl, reading its results one at a time
+func AnalyseCrawl(objectList URLObjectList) (CrawlAnalysis, error) {
 	var analysis CrawlAnalysis
+	err := objectList.ForEachOutOfScope(func(url string, found *OutOfScopeURL) error {
+		analysis.TotalOutOfScopeURLs++
+		if trap, ok := strings.CutPrefix(found.Reason, outOfScopeTrap+": "); ok {
+			analysis.countTrap(trap)
+		}
+		return nil
+	})
+	if err != nil {
+		return analysis, err
+	}
+	images := make(map[string]PageImage) // each distinct image and resource is only counted once
+	resources := make(map[string]PageResource)
+	externalLinks := make(map[string]ExternalLink)
+	var slowest, largest []RankedURL // only the top rankedURLs are kept
+	var totalTTFB time.Duration
+	responded := 0
 
-	for _, URLObject := range objectList.URLObjects {
-		// 1. Core data
-		analysis.TotalInternalURLs = len(objectList.URLObjects)
+	// 1. Core data
+	analysis.TotalInternalURLs = objectList.Len()
 
+	err = objectList.ForEach(func(url string, URLObject *URLObject) error {
 		// 2. Status
 		if URLObject.PageStatus >= 500 {
 			analysis.Total500s++
@@ -55,7 +113,150 @@ func AnalyseCrawl(objectList URLObjectList) CrawlAnalysis {
 		if URLObject.NoIndex {
 			analysis.TotalNoIndexes++
 		}
+
+		// 6. Structured data
+		for _, item := range URLObject.StructuredData {
+			if item.Type == "" {
+				continue
+			}
+			if analysis.StructuredDataTypes == nil {
+				analysis.StructuredDataTypes = make(map[string]int)
+			}
+			analysis.StructuredDataTypes[item.Type]++
+		}
+		if len(structuredDataProblems(URLObject.StructuredData)) > 0 {
+			analysis.TotalStructuredDataProblems++
+		}
+
+		// 7. Social tags
+		for _, problem := range URLObject.SocialProblems {
+			switch problem {
+			case socialMissingOGTitle:
+				analysis.TotalMissingOGTitles++
+			case socialMissingOGImage:
+				analysis.TotalMissingOGImages++
+			case socialMissingOGURL:
+				analysis.TotalMissingOGURLs++
+			case socialOGURLMismatch:
+				analysis.TotalOGURLMismatches++
+			case socialBrokenOGImage:
+				analysis.TotalBrokenOGImages++
+			}
+		}
+
+		// 8. hreflang
+		if len(URLObject.HreflangProblems) > 0 {
+			analysis.TotalHreflangProblems++
+		}
+
+		// 9. Images
+		analysis.TotalImagesMissingAlt += imagesMissingAlt(URLObject.Images)
+		for _, image := range URLObject.Images {
+			images[image.URL] = image
+		}
+
+		// 10. Resources
+		for _, resource := range URLObject.Resources {
+			resources[resource.URL] = resource
+		}
+		if brokenResources(URLObject.Resources) > 0 {
+			analysis.TotalPagesBrokenResources++
+		}
+		for _, link := range URLObject.ExternalLinks {
+			externalLinks[link.URL] = link
+		}
+		if brokenExternalLinks(URLObject.ExternalLinks) > 0 {
+			analysis.TotalPagesBrokenExternal++
+		}
+		if URLObject.Trap != "" {
+			analysis.countTrap(URLObject.Trap)
+		}
+
+		// 11. Response metadata (of URLs that responded)
+		if URLObject.PageStatus != 0 {
+			responded++
+			totalTTFB += URLObject.Response.TTFB
+			slowest = topRankedURLs(append(slowest, RankedURL{url, URLObject.Response.DownloadTime.Milliseconds()}), rankedURLs)
+			largest = topRankedURLs(append(largest, RankedURL{url, URLObject.Response.Size}), rankedURLs)
+			if URLObject.PageStatus == 200 && !isHTMLType(URLObject.Response.ContentType) && looksLikeHTMLURL(url) {
+				analysis.TotalNonHTMLOnHTMLURLs++
+			}
+		}
+
+		// 12. Custom search
+		for name, match := range URLObject.Search {
+			if analysis.SearchResults == nil {
+				analysis.SearchResults = make(map[string]int)
+			}
+			if _, ok := analysis.SearchResults[name]; !ok {
+				analysis.SearchResults[name] = 0 // so rules that flag nothing still get a column
+			}
+			if match.Flagged {
+				analysis.SearchResults[name]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return CrawlAnalysis{}, err
+	}
+
+	analysis.TotalImages = len(images)
+	for _, image := range images {
+		if image.Fetched == nil {
+			continue
+		}
+		if isBrokenStatus(image.Fetched.Status) {
+			analysis.TotalBrokenImages++
+		}
+		if isOversized(image, objectList.Config.MaxImageKB) {
+			analysis.TotalOversizedImages++
+		}
+	}
+
+	if responded > 0 {
+		analysis.AverageTTFBMs = (totalTTFB / time.Duration(responded)).Milliseconds()
 	}
+	analysis.SlowestPages, analysis.LargestPages = slowest, largest
 
-	return analysis
+	analysis.TotalResources = len(resources)
+	for _, resource := range resources {
+		if resource.IsBroken() {
+			analysis.TotalBrokenResources++
+		}
+		if resource.BlockedByRobots {
+			analysis.TotalBlockedResources++
+		}
+	}
+
+	analysis.TotalExternalLinks = len(externalLinks)
+	for _, link := range externalLinks {
+		if link.IsBroken() {
+			analysis.TotalBrokenExternalLinks++
+		}
+	}
+
+	return analysis, nil
+}
+
+func (analysis *CrawlAnalysis) countTrap(trap string) {
+	if analysis.Traps == nil {
+		analysis.Traps = make(map[string]int)
+	}
+	analysis.Traps[trap]++
+	analysis.TotalTrapURLs++
+}
+
+// returns the n URLs with the highest values, highest first
+func topRankedURLs(ranked []RankedURL, n int) []RankedURL {
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Value != ranked[j].Value {
+			return ranked[i].Value > ranked[j].Value
+		}
+		return ranked[i].URL < ranked[j].URL
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
 }
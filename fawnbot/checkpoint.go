@@ -0,0 +1,211 @@
+package fawnbot
+
+/*
+| - - checkpoint.go - -
+| Saves and restores the state of interrupted crawls, so they can resume where they stopped
+*/
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Storage keeps checkpoints between runs
+type Storage interface {
+	Save(key string, data []byte) error
+	Load(key string) ([]byte, bool, error) // false if there's nothing stored under key
+	Delete(key string) error
+}
+
+// DirStorage stores each key as a JSON file in a directory, created when first needed
+type DirStorage string
+
+func (d DirStorage) path(key string) string {
+	return filepath.Join(string(d), key+".json")
+}
+
+func (d DirStorage) Save(key string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %v", err)
+	}
+
+	// write then rename, so a kill mid-write can't corrupt the previous checkpoint
+	path := d.path(key)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+
+	return nil
+}
+
+func (d DirStorage) Load(key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	return data, true, nil
+}
+
+func (d DirStorage) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %v", err)
+	}
+	return nil
+}
+
+// - - -
+
+// returns a site's default crawl ID: its host and a hash of its run key. Each run of the site resumes the last unfinished one.
+func checkpointKey(crawlConfig CrawlConfig) string {
+	hash := sha1.Sum([]byte(crawlConfig.RunKey()))
+	host := strings.ReplaceAll(extractHost(crawlConfig.Root), ":", "_")
+	return fmt.Sprintf("%s-%s", host, hex.EncodeToString(hash[:6]))
+}
+
+var crawlIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// crawl IDs name checkpoint files, so are limited to letters, digits, dots, dashes and underscores
+func validateCrawlID(id string) error {
+	if !crawlIDPattern.MatchString(id) || strings.Trim(id, ".") == "" {
+		return fmt.Errorf("invalid crawl ID '%s': use only letters, digits, '.', '-' and '_'", id)
+	}
+	return nil
+}
+
+func saveCheckpoint(storage Storage, id string, state *crawlState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+	return storage.Save(id, data)
+}
+
+// returns the crawl state checkpointed under id, if there is one
+func loadCheckpoint(storage Storage, id string) (*crawlState, bool, error) {
+	data, ok, err := storage.Load(id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	var state crawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, false, fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+
+	return &state, true, nil
+}
+
+func removeCheckpoint(storage Storage, id string) error {
+	return storage.Delete(id)
+}
+
+// - - -
+
+// checkpointer owns a crawl's store, saving it under the crawl ID so an interrupted or killed crawl can resume
+type checkpointer struct {
+	id       string
+	storage  Storage     // where in-memory state is checkpointed. nil to not checkpoint it
+	state    *crawlState // the in-memory store, unless disk is set
+	disk     *diskStore
+	diskPath string
+}
+
+// opens the crawl's store, resuming it from any checkpoint of the same root. Crawls with a DiskStoreDir keep their
+// state in a database named after the crawl ID, which is its own checkpoint. Others use storage, if set.
+func openCheckpointer(id, root string, config ProgramConfig, storage Storage, logger Logger) (*checkpointer, error) {
+	cp := &checkpointer{id: id, storage: storage}
+
+	if config.DiskStoreDir != "" {
+		cp.diskPath = filepath.Join(config.DiskStoreDir, id+".db")
+		disk, resumed, err := openDiskStore(cp.diskPath, root, config.BloomFilterURLs)
+		if err != nil {
+			return nil, err
+		}
+		if resumed {
+			logger.Printf("(i) Resuming crawl %s from disk store: %d URLs crawled, %d queued", id, disk.Crawled(), disk.Queued())
+		}
+		cp.disk = disk
+		return cp, nil
+	}
+
+	cp.state = newCrawlState(root)
+	if storage != nil {
+		checkpoint, ok, err := loadCheckpoint(storage, id)
+		if err != nil {
+			logger.Printf("[!] Error loading checkpoint, starting from root: %v", err)
+		} else if ok && checkpoint.Root == root {
+			logger.Printf("(i) Resuming crawl %s from checkpoint: %d URLs crawled, %d queued", id, len(checkpoint.URLObjects), len(checkpoint.URLQueue))
+			if checkpoint.Started.IsZero() {
+				checkpoint.Started = cp.state.Started // checkpointed before crawls recorded their start
+			}
+			cp.state = checkpoint
+		}
+	}
+	return cp, nil
+}
+
+func (cp *checkpointer) store() crawlStore {
+	if cp.disk != nil {
+		return cp.disk
+	}
+	return cp.state
+}
+
+// when the crawl first started, before any interruptions
+func (cp *checkpointer) started() time.Time {
+	if cp.disk != nil {
+		return cp.disk.started
+	}
+	return cp.state.Started
+}
+
+// saves the crawl so far. Only call between pages, so the checkpoint is consistent.
+func (cp *checkpointer) save() error {
+	if cp.disk != nil {
+		return cp.disk.Sync()
+	}
+	if cp.storage == nil {
+		return nil
+	}
+	return saveCheckpoint(cp.storage, cp.id, cp.state)
+}
+
+// checkpoints an interrupted crawl, closing its store
+func (cp *checkpointer) interrupt() error {
+	if cp.disk != nil {
+		return cp.disk.Close()
+	}
+	return cp.save()
+}
+
+// closes the store of a crawl that's stopped for good, removing its checkpoint if it finished
+func (cp *checkpointer) finish(finished bool) error {
+	if cp.disk != nil {
+		if err := cp.disk.Close(); err != nil || !finished {
+			return err
+		}
+		if err := os.Remove(cp.diskPath); err != nil {
+			return fmt.Errorf("failed to remove disk store: %v", err)
+		}
+		return nil
+	}
+	if cp.storage == nil || !finished {
+		return nil
+	}
+	return removeCheckpoint(cp.storage, cp.id)
+}
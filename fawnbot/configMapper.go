@@ -0,0 +1,219 @@
+package fawnbot
+
+/*
+| - - configMapper.go - -
+| Maps control sheet columns onto CrawlConfig fields by header name
+|
+| Every exported CrawlConfig field is settable from a column whose header matches
+| the field's JSON name (case, spaces and punctuation are ignored) or one of the
+| aliases in its `sheet` struct tag. New fields therefore work without any changes here.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const statusHeader = "Status"
+
+// maps a normalised header name to the index path of a CrawlConfig field
+type configFieldMap map[string][]int
+
+var crawlConfigFields = buildConfigFieldMap(reflect.TypeOf(CrawlConfig{}), nil)
+
+func buildConfigFieldMap(t reflect.Type, parent []int) configFieldMap {
+	fields := make(configFieldMap)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		index := append(append([]int{}, parent...), i)
+
+		// embedded structs contribute their own fields, as they do in JSON
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for key, path := range buildConfigFieldMap(field.Type, index) {
+				fields[key] = path
+			}
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[normaliseHeader(name)] = index
+		for _, alias := range strings.Split(field.Tag.Get("sheet"), ",") {
+			if alias != "" {
+				fields[normaliseHeader(alias)] = index
+			}
+		}
+	}
+
+	return fields
+}
+
+// lowercases a header and strips everything but letters and digits
+func normaliseHeader(header string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(header) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// returns the field index path for each sheet column (nil if unknown), and the index of the status column (-1 if absent)
+func mapSheetHeaders(headers []interface{}, logger Logger) ([][]int, int) {
+	columns := make([][]int, len(headers))
+	statusColumn := -1
+
+	for i, cell := range headers {
+		header := normaliseHeader(fmt.Sprint(cell))
+		if header == "" {
+			continue
+		}
+		if header == normaliseHeader(statusHeader) {
+			statusColumn = i
+			continue
+		}
+		if path, ok := crawlConfigFields[header]; ok {
+			columns[i] = path
+		} else {
+			logger.Printf("(i) Ignoring unknown control sheet column '%v'", cell)
+		}
+	}
+
+	return columns, statusColumn
+}
+
+// builds a CrawlConfig from a single sheet row using the mapped columns. Blank cells leave the field at its default.
+func parseCrawlConfigRow(columns [][]int, row []interface{}) (CrawlConfig, error) {
+	var config CrawlConfig
+	value := reflect.ValueOf(&config).Elem()
+
+	var problems []string
+	for i, cell := range row {
+		if i >= len(columns) || columns[i] == nil {
+			continue
+		}
+
+		field := value.FieldByIndex(columns[i])
+		if err := setConfigField(field, fmt.Sprint(cell)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", value.Type().FieldByIndex(columns[i]).Name, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return config, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return config, nil
+}
+
+// parses a raw cell into a field. Structs, maps and slices of anything other than strings are read as JSON.
+func setConfigField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+
+	if field.Kind() == reflect.Pointer {
+		target := reflect.New(field.Type().Elem())
+		if err := setConfigField(target.Elem(), raw); err != nil {
+			return err
+		}
+		field.Set(target)
+		return nil
+	}
+
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration '%s'", raw)
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		val, err := parseSheetBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number '%s'", raw)
+		}
+		field.SetInt(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number '%s'", raw)
+		}
+		field.SetFloat(val)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(raw, "[") {
+			var items []string
+			for _, item := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' }) {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items).Convert(field.Type()))
+			return nil
+		}
+		fallthrough
+	default:
+		if err := json.Unmarshal([]byte(raw), field.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func parseSheetBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "true", "yes", "y", "1":
+		return true, nil
+	case "false", "no", "n", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean '%s'", raw)
+}
+
+func isBlankRow(row []interface{}) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(fmt.Sprint(cell)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// converts a zero-based column index to its A1 letter (0 -> A, 26 -> AA)
+func columnLetter(index int) string {
+	letter := ""
+	for index >= 0 {
+		letter = string(rune('A'+index%26)) + letter
+		index = index/26 - 1
+	}
+	return letter
+}
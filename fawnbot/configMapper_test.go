@@ -0,0 +1,128 @@
+package fawnbot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMapSheetHeaders(t *testing.T) {
+	headers := []interface{}{"Root URL", "crawl_frequency", "Status", "", "Unknown Column", "Max Crawl Depth", "Respect Robots?"}
+	columns, statusColumn := mapSheetHeaders(headers, discardLogger{})
+	if statusColumn != 2 {
+		t.Errorf("status column %d, want 2", statusColumn)
+	}
+	if columns[2] != nil || columns[3] != nil || columns[4] != nil {
+		t.Errorf("status, blank and unknown columns mapped: %v", columns)
+	}
+
+	row := []interface{}{" https://example.com/ ", "weekly", "OK", "", "ignored", "3", "yes"}
+	config, err := parseCrawlConfigRow(columns, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Root != "https://example.com/" || config.CrawlFrequency != "weekly" {
+		t.Errorf("Root %q, CrawlFrequency %q", config.Root, config.CrawlFrequency)
+	}
+	if config.MaxCrawlDepth == nil || *config.MaxCrawlDepth != 3 || config.RespectRobots == nil || !*config.RespectRobots {
+		t.Errorf("overrides: MaxCrawlDepth %v, RespectRobots %v", config.MaxCrawlDepth, config.RespectRobots)
+	}
+	if config.SkipTraps != nil {
+		t.Error("unset override was set")
+	}
+}
+
+// every alias in a sheet tag maps to its field, ignoring case, spaces and punctuation
+func TestMapSheetHeaderAliases(t *testing.T) {
+	for _, header := range []string{"URL", "site", "ROOT", "Root-URL"} {
+		columns, _ := mapSheetHeaders([]interface{}{header}, discardLogger{})
+		config, err := parseCrawlConfigRow(columns, []interface{}{"https://example.com/"})
+		if err != nil || config.Root != "https://example.com/" {
+			t.Errorf("header %q: Root %q, err %v", header, config.Root, err)
+		}
+	}
+}
+
+func TestParseCrawlConfigRowValues(t *testing.T) {
+	columns, _ := mapSheetHeaders([]interface{}{"Root", "Extract", "Crawl Scope", "Keep Old Crawls"}, discardLogger{})
+	row := []interface{}{
+		"https://example.com/",
+		`[{"Name": "Price", "Selector": ".price"}]`,
+		`{"Hosts": ["blog.example.com"], "ExcludeParams": ["sort"]}`,
+		"TRUE",
+	}
+	config, err := parseCrawlConfigRow(columns, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Extractors) != 1 || config.Extractors[0].Name != "Price" {
+		t.Errorf("Extractors %+v", config.Extractors)
+	}
+	if config.Scope == nil || len(config.Scope.Hosts) != 1 || config.Scope.ExcludeParams[0] != "sort" {
+		t.Errorf("Scope %+v", config.Scope)
+	}
+	if !config.KeepOldCrawls {
+		t.Error("KeepOldCrawls not set")
+	}
+
+	// a short row leaves the rest at their defaults
+	if config, err := parseCrawlConfigRow(columns, []interface{}{"https://example.com/"}); err != nil || config.Scope != nil {
+		t.Errorf("short row: Scope %+v, err %v", config.Scope, err)
+	}
+}
+
+// every bad cell is reported, by field
+func TestParseCrawlConfigRowProblems(t *testing.T) {
+	columns, _ := mapSheetHeaders([]interface{}{"Max Crawl Depth", "Keep Old Crawls", "Crawl Scope"}, discardLogger{})
+	_, err := parseCrawlConfigRow(columns, []interface{}{"deep", "maybe", "{not json"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"MaxCrawlDepth: invalid number 'deep'", "KeepOldCrawls: invalid boolean 'maybe'", "Scope: invalid JSON"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+}
+
+func TestSetConfigField(t *testing.T) {
+	var fields struct {
+		Hosts    []string
+		JSONList []string
+		Delay    time.Duration
+		Ratio    float64
+	}
+	value := reflect.ValueOf(&fields).Elem()
+	cells := map[string]string{
+		"Hosts":    "a.example.com, b.example.com\nc.example.com,",
+		"JSONList": `["x, y", "z"]`,
+		"Delay":    "1m30s",
+		"Ratio":    "0.5",
+	}
+	for name, raw := range cells {
+		if err := setConfigField(value.FieldByName(name), raw); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if strings.Join(fields.Hosts, " ") != "a.example.com b.example.com c.example.com" {
+		t.Errorf("comma and line separated list: %q", fields.Hosts)
+	}
+	if len(fields.JSONList) != 2 || fields.JSONList[0] != "x, y" {
+		t.Errorf("JSON list: %q", fields.JSONList)
+	}
+	if fields.Delay != 90*time.Second || fields.Ratio != 0.5 {
+		t.Errorf("Delay %v, Ratio %v", fields.Delay, fields.Ratio)
+	}
+	if err := setConfigField(value.FieldByName("Delay"), "soon"); err == nil {
+		t.Error("invalid duration: expected an error")
+	}
+}
+
+func TestColumnLetter(t *testing.T) {
+	for index, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnLetter(index); got != want {
+			t.Errorf("columnLetter(%d) = %s, want %s", index, got, want)
+		}
+	}
+}
@@ -1,18 +1,81 @@
 package fawnbot
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
 	"io"
+	"log"
 	"net/http"
-	"regexp"
+	"net/http/httptrace"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/html"
 )
 
+// Crawler crawls a single site. Create one with NewCrawler, then call Run.
+type Crawler struct {
+	crawlConfig CrawlConfig
+	config      ProgramConfig
+	client      *http.Client
+	logger      Logger
+	scope       Scope
+	storage     Storage
+	exporters   []Exporter
+	plugins     []Plugin
+	crawlID     string       // names the crawl's checkpoints. Defaults to one per site
+	checker     *linkChecker // shared by checks of uncrawled URLs, such as og:image
+	linkCache   *LinkCache   // external link checks, shared with other crawls (see WithLinkCache)
+	session     *session     // the client's headers, cookies and credentials for the site
+	scheduler   *hostScheduler
+	scheduled   *http.Client // the client, with each request waiting for its host's turn in scheduler
+}
+
+// Creates a crawler for the site in crawlConfig. Without options it uses DefaultProgramConfig, logs nothing,
+// stays on the root's host, checkpoints to the program config's CheckpointDir and exports nothing.
+func NewCrawler(crawlConfig CrawlConfig, opts ...Option) *Crawler {
+	c := &Crawler{
+		crawlConfig: crawlConfig,
+		config:      DefaultProgramConfig(),
+		client:      defaultClient,
+		logger:      discardLogger{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.client, c.session = newSessionClient(c.client)
+	config := crawlConfig.EffectiveConfig(c.config)
+	c.scheduler = newHostScheduler(config.AdaptiveDelay, c.logger)
+	c.scheduled = newScheduledClient(c.client, c.scheduler, crawlInterval(config, Robots{}))
+	c.checker = newLinkChecker(c.scheduled) // resources and images take turns with the site's pages
+	// built-in plugins run first, so custom plugins can see their results
+	c.plugins = append(builtinPlugins(c), c.plugins...)
+	return c
+}
+
+// The outcome of a finished crawl
+type CrawlResult struct {
+	URLObjectList
+	Analysis CrawlAnalysis
+	CrawlID  string // resumes the crawl if it's interrupted (see WithCrawlID)
+	Root     string // the root after www. normalisation
+	Robots   Robots
+	Started  time.Time
+	Duration time.Duration
+}
+
+// A crawl's results. Those of a finished crawl are read from its store with ForEach, ForEachOutOfScope and Get, so
+// URLObjects and OutOfScope are only set for crawls kept in memory and those read from JSON. Close the list once done with it.
 type URLObjectList struct {
 	URLObjects map[string]*URLObject
+	OutOfScope map[string]*OutOfScopeURL `json:",omitempty"` // URLs found but not crawled
+	Config     ProgramConfig             // the effective settings the crawl ran with
+
+	results resultStore  // nil if URLObjects holds the results
+	close   func() error // closes the store, removing its checkpoint
 }
 
 type URLObject struct {
@@ -33,156 +96,160 @@ type URLObject struct {
 	// postcrawl metrics
 	IsOrphan             bool // collected
 	IsOnSitemap          bool
-	IsCanonicalIndexable bool // collected
-	IsSelfCanonicalising bool // collected
+	IsCanonicalIndexable bool         // collected
+	IsSelfCanonicalising bool         // collected
+	Response             ResponseMeta `diff:"-"` // timings and sizes vary between crawls, so aren't diffed
+	// structured and social metadata
+	StructuredData []StructuredItem  `json:",omitempty"` // JSON-LD, Microdata and RDFa items
+	OpenGraph      map[string]string `json:",omitempty"` // og:* tags, by property
+	TwitterCard    map[string]string `json:",omitempty"` // twitter:* tags, by name
+	SocialProblems []string          `json:",omitempty"`
+	Images         []PageImage       `json:",omitempty"`
+	Resources      []PageResource    `json:",omitempty"` // only if resources are checked
+	ExternalLinks  []ExternalLink    `json:",omitempty"` // only if external links are checked
+	// hreflang (see hreflang.go)
+	Hreflang         []HreflangLink `json:",omitempty"`
+	HreflangProblems []string       `json:",omitempty"` // collected
+	// custom extraction (see extract.go)
+	Extracted map[string]string      `json:",omitempty"` // extractor results, by name
+	Search    map[string]SearchMatch `json:",omitempty"` // search rule results, by name
+	// crawler traps (see traps.go)
+	Trap string `json:",omitempty"` // why the URL looks like a crawler trap, if it does
+	// plugin metrics
+	Columns map[string]string `json:",omitempty"` // custom columns, by name
+	Issues  []string          `json:",omitempty"`
 }
 
 type QueueEntry struct {
-	url        string
-	crawlDepth int
+	URL        string `json:"URL"`
+	CrawlDepth int    `json:"CrawlDepth"`
+	Trap       string `json:"Trap,omitempty"` // why the URL looks like a crawler trap, if it does
 }
 
-func fetchURLQuick(url string) (string, int, string, error) {
-	return fetchURL(url, ProgramConfig{RespectRobots: false}, Robots{})
+// everything needed to carry on a crawl from where it stopped
+type crawlState struct {
+	Root        string                    `json:"Root"`
+	Started     time.Time                 `json:"Started"`
+	URLQueue    []QueueEntry              `json:"URLQueue"`
+	VisitedURLs map[string]bool           `json:"VisitedURLs"`
+	URLObjects  map[string]*URLObject     `json:"URLObjects"`
+	OutOfScope  map[string]*OutOfScopeURL `json:"OutOfScope,omitempty"`
+	Traps       *trapCounts               `json:"Traps,omitempty"` // the trap detector's counts
 }
 
-// Send HTTP request to URL, returning HTML, response code, and any errors
-func fetchURL(url string, config ProgramConfig, robots Robots) (string, int, string, error) {
-	if config.RespectRobots && robots.CrawlDelay > 0 {
-		time.Sleep(time.Duration(robots.CrawlDelay) * time.Second)
+func newCrawlState(root string) *crawlState {
+	return &crawlState{
+		Root:        root,
+		Started:     time.Now(),
+		URLQueue:    []QueueEntry{{URL: root}},
+		VisitedURLs: map[string]bool{root: true},
+		URLObjects:  make(map[string]*URLObject),
+	}
+}
+
+var defaultClient = newNoRedirectClient(&http.Client{})
+
+// returns a copy of client that doesn't follow redirects, so they're recorded as 3xxs
+func newNoRedirectClient(client *http.Client) *http.Client {
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &noRedirect
+}
+
+type fetchResult struct {
+	Response *http.Response // the body has already been read and closed
+	Body     []byte         // decompressed
+	Meta     ResponseMeta
+}
+
+// Limits on what fetchPage reads. The zero value reads every body in full.
+type fetchOptions struct {
+	MaxBodySize int64                         // in bytes, after decompression. 0 for no limit
+	ReadBody    func(contentType string) bool // nil reads every body. Skipped bodies are sized from Content-Length
+}
+
+// Send HTTP request to URL, returning the response, its body and metadata.
+// Unreachable URLs return a nil result and no error, unless ctx was cancelled.
+func fetchPage(ctx context.Context, client *http.Client, url string, opts fetchOptions) (*fetchResult, error) {
+	var meta ResponseMeta
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotConn:              func(info httptrace.GotConnInfo) { meta.ServerIP = remoteIP(info.Conn.RemoteAddr()) },
+		GotFirstResponseByte: func() { meta.TTFB = time.Since(start) },
 	}
 
 	// Be respectful to the server by setting a user-agent 🙇🙇🙇
-	request, err := http.NewRequest("GET", url, nil)
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", url, nil)
 	if err != nil {
-		return "", 0, "", err
+		return nil, err
 	}
 
 	request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; fawnbot)")
-
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		},
-	}
+	// asking for compression ourselves stops the transport decompressing it, so the transfer size can be measured
+	request.Header.Set("Accept-Encoding", "gzip, deflate")
 
 	response, err := client.Do(request)
 	if err != nil {
-		return "", 0, "", nil
+		return nil, ctx.Err()
 	}
 	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return "", response.StatusCode, "", err
+	meta.readHeaders(response.Header)
+
+	// check the type before downloading anything, so large files that won't be parsed aren't read
+	if opts.ReadBody != nil && !opts.ReadBody(meta.ContentType) {
+		meta.DownloadTime = time.Since(start)
+		meta.TransferSize, meta.Size, meta.BodySkipped = response.ContentLength, -1, true
+		if meta.ContentEncoding == "" {
+			meta.Size = response.ContentLength
+		}
+		return &fetchResult{Response: response, Meta: meta}, nil
 	}
 
-	redirectTo := ""
-	if response.StatusCode >= 300 && response.StatusCode < 400 {
-		redirectTo = response.Header.Get("Location")
+	var reader io.Reader = response.Body
+	if opts.MaxBodySize > 0 {
+		reader = io.LimitReader(reader, opts.MaxBodySize+1) // one byte over, to tell if it was cut off
 	}
-
-	return string(body), response.StatusCode, redirectTo, nil
-}
-
-func parseHTML(htmlString string) (bool, bool, string, string, string, string) {
-	indexable := true
-	noIndex := false
-	canonical := ""
-	metaDescription := ""
-	metaTitle := ""
-	h1 := ""
-
-	doc, err := html.Parse(strings.NewReader(htmlString))
+	counter := &countingReader{r: reader}
+	raw, err := io.ReadAll(counter)
 	if err != nil {
-		return indexable, noIndex, canonical, metaDescription, metaTitle, h1
-	}
-
-	// 2. recursive function to parse html
-	var traverseHTML func(*html.Node)
-	traverseHTML = func(n *html.Node) {
-		if n.Type == html.ElementNode {
-			switch n.Data {
-			case "meta":
-				var name, content string
-				for _, attr := range n.Attr {
-					switch strings.ToLower(attr.Key) {
-					case "name":
-						name = strings.ToLower(attr.Val)
-					case "content":
-						content = attr.Val
-					}
-				}
-
-				if name == "robots" && strings.Contains(strings.ToLower(content), "noindex") {
-					indexable = false
-					noIndex = true
-				}
-				if name == "description" {
-					metaDescription = content
-				}
-
-			case "link":
-				var rel, href string
-				for _, attr := range n.Attr {
-					switch strings.ToLower(attr.Key) {
-					case "rel":
-						rel = strings.ToLower(attr.Val)
-					case "href":
-						href = attr.Val
-					}
-				}
-				if rel == "canonical" && href != "" {
-					canonical = href
-				}
+		return nil, err
+	}
+	body := decodeBody(raw, response.Header.Get("Content-Encoding"), opts.MaxBodySize)
+	if opts.MaxBodySize > 0 && (int64(len(body)) > opts.MaxBodySize || counter.n > opts.MaxBodySize) {
+		body = body[:min(int64(len(body)), opts.MaxBodySize)]
+		meta.Truncated = true
+	}
 
-			case "title":
-				if metaTitle == "" && n.FirstChild != nil {
-					metaTitle = n.FirstChild.Data
-				}
+	meta.DownloadTime = time.Since(start)
+	meta.TransferSize = counter.n
+	meta.Size = int64(len(body))
+	meta.sniffCharset(body)
 
-			case "h1":
-				if h1 == "" && n.FirstChild != nil {
-					h1 = n.FirstChild.Data
-				}
-			}
-		}
+	return &fetchResult{Response: response, Body: body, Meta: meta}, nil
+}
 
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			traverseHTML(c)
-		}
+// Send HTTP request to URL, returning HTML, response code, redirect location, and any errors
+func fetchURL(ctx context.Context, client *http.Client, url string) (string, int, string, error) {
+	result, err := fetchPage(ctx, client, url, fetchOptions{})
+	if result == nil {
+		return "", 0, "", err
 	}
 
-	traverseHTML(doc)
-	return indexable, noIndex, canonical, metaDescription, metaTitle, h1
+	return string(result.Body), result.Response.StatusCode, redirectLocation(result.Response), nil
 }
 
-// returns a list of hrefs from an html string
-func extractLinks(htmlString string) []string {
-	var links []string
-	tokenizer := html.NewTokenizer(strings.NewReader(htmlString))
-
-	for {
-		tt := tokenizer.Next()
-		switch tt {
-		case html.ErrorToken:
-			return links
-		case html.StartTagToken:
-			token := tokenizer.Token()
-			if token.Data == "a" {
-				for _, attr := range token.Attr {
-					if attr.Key == "href" {
-						links = append(links, attr.Val)
-					}
-				}
-			}
-		}
+func redirectLocation(response *http.Response) string {
+	if response.StatusCode >= 300 && response.StatusCode < 400 {
+		return response.Header.Get("Location")
 	}
+	return ""
 }
 
 // returns a URL's preferred www. config by checking for redirects
-func setWWWPreference(root string) (string, error) {
-	_, status, redirectTo, err := fetchURLQuick(root)
+func setWWWPreference(ctx context.Context, client *http.Client, root string) (string, error) {
+	_, status, redirectTo, err := fetchURL(ctx, client, root)
 	if err != nil {
 		return root, err
 	}
@@ -192,7 +259,6 @@ func setWWWPreference(root string) (string, error) {
 		redirectHost := extractHost(redirectTo)
 
 		if rootHost != redirectHost {
-			fmt.Printf("(i) Detected www preference: %s -> %s\n", root, redirectTo)
 			return redirectTo, nil
 		}
 	}
@@ -208,9 +274,26 @@ func extractHost(url string) string {
 	return parts[0]
 }
 
+// returns the minimum time between requests to a host, from its robots crawl delay and MaxCrawlsPerSecond (whichever is slower)
+func crawlInterval(config ProgramConfig, robots Robots) time.Duration {
+	var interval time.Duration
+	if config.RespectRobots && robots.CrawlDelay > 0 {
+		interval = time.Duration(robots.CrawlDelay * float64(time.Second))
+	}
+	if config.MaxCrawlsPerSecond > 0 {
+		if rateInterval := time.Second / time.Duration(config.MaxCrawlsPerSecond); rateInterval > interval {
+			interval = rateInterval
+		}
+	}
+	return interval
+}
+
 func normaliseWWW(url, preferredRoot string) string {
 	urlHost := extractHost(url)
 	preferredHost := extractHost(preferredRoot)
+	if strings.TrimPrefix(urlHost, "www.") != strings.TrimPrefix(preferredHost, "www.") {
+		return url // another site, e.g. www.example.org on a crawl of example.com
+	}
 
 	if strings.Contains(preferredHost, "www.") && !strings.Contains(urlHost, "www.") {
 		return strings.Replace(url, urlHost, preferredHost, 1)
@@ -220,158 +303,505 @@ func normaliseWWW(url, preferredRoot string) string {
 	return url
 }
 
-func crawl(root string, config ProgramConfig, robots Robots) (URLObjectList, error) {
+// A queued URL once it's been fetched (unless robots.txt blocks it) and parsed, passed from a fetch worker to crawl
+type fetchedPage struct {
+	seq      int // the order it was popped in
+	entry    QueueEntry
+	blocked  bool // by robots.txt
+	result   *fetchResult
+	scan     pageScan
+	doc      *html.Node              // of 200 HTML responses, if a plugin reads it
+	visitors map[string]tokenVisitor // what scanning plugins gathered from 200 HTML responses, by plugin name
+	err      error
+}
 
-	// 1. prepare regex to only crawl same-site URLs
-	host := extractHost(root)
-	regexPattern := fmt.Sprintf("^https?://%s.*", host)
-	rootRegex, err := regexp.Compile(regexPattern)
+// fetches a URL once its host's turn comes, then frees the host for its next request
+func (c *Crawler) fetchFromHost(ctx context.Context, scheduler *hostScheduler, url string, interval time.Duration, opts fetchOptions) (*fetchResult, error) {
+	host := strings.ToLower(extractHost(url))
+	if err := scheduler.acquire(ctx, host, interval); err != nil {
+		return nil, err
+	}
+	result, err := fetchPage(ctx, c.client, url, opts)
+	if result == nil {
+		scheduler.release(host, 0, 0, nil)
+		return nil, err
+	}
+	scheduler.release(host, result.Response.StatusCode, result.Meta.TTFB, result.Response.Header)
+	return result, err
+}
 
-	if err != nil {
-		fmt.Println("[!] Error compiling regex: ", err)
-		return URLObjectList{}, err
+// fetches and parses a queued URL once its host's turn comes. Robots-blocked URLs aren't fetched if robots are respected.
+func (c *Crawler) fetchEntry(ctx context.Context, scheduler *hostScheduler, entry QueueEntry, interval time.Duration, blocked bool, config ProgramConfig, opts fetchOptions) fetchedPage {
+	page := fetchedPage{entry: entry, blocked: blocked}
+	if config.RespectRobots && blocked {
+		return page
 	}
 
-	// 2. prepare data structures
-	URLObjects := make(map[string]*URLObject)
+	fetchedAt := time.Now()
+	page.result, page.err = c.fetchFromHost(ctx, scheduler, entry.URL, interval, opts)
+	// pages that find the session has ended are fetched again once logged back in
+	if page.result != nil && c.session.loggedOut(entry.URL, page.result) && c.session.relogin(ctx, c.scheduled, c.logger, fetchedAt) {
+		page.result, page.err = c.fetchFromHost(ctx, scheduler, entry.URL, interval, opts)
+	}
+	if page.result == nil {
+		return page
+	}
+	response, meta := page.result.Response, page.result.Meta
+
+	// scan the page once for its links, meta and headings. Only 200s keep their meta, and their DOM if a plugin reads it.
+	readsBody, readsDoc := c.pageReads()
+	if isHTMLType(meta.ContentType) {
+		var visitors []tokenVisitor
+		if response.StatusCode == 200 {
+			page.visitors = c.pageVisitors(entry.URL)
+			for _, v := range page.visitors {
+				visitors = append(visitors, v)
+			}
+		}
+		scan, err := scanPage(bytes.NewReader(page.result.Body), visitors...)
+		if err != nil {
+			scan = pageScan{Indexable: true}
+			page.visitors = nil
+		}
+		if response.StatusCode == 200 {
+			page.scan = scan
+			if readsDoc {
+				page.doc, _ = html.Parse(bytes.NewReader(page.result.Body)) // can't fail reading from memory
+			}
+		} else {
+			page.scan = pageScan{Links: scan.Links}
+		}
+	} else if response.StatusCode == 200 {
+		page.scan.Indexable = true // recorded, but not parsed
+		if meta.ContentType == "application/pdf" && page.result.Body != nil {
+			page.scan.MetaTitle, page.scan.Links = parsePDF(page.result.Body)
+		}
+	}
+	if !readsBody {
+		page.result.Body = nil // so pages fetched ahead don't hold their bodies
+	}
+	return page
+}
 
-	visitedURLs := make(map[string]bool)
+// crawls every URL in the store's frontier, fetching up to config.Workers at once. If ctx is cancelled, returns ctx.Err()
+// with the store left ready to resume. checkpoint is called every CheckpointEvery seconds, between pages.
+func (c *Crawler) crawl(ctx context.Context, store crawlStore, root string, config ProgramConfig, robots Robots, scope Scope, checkpoint func() error) (URLObjectList, error) {
+	// 1. prepare data structures
+	scheduler := c.scheduler
+	workers := max(config.Workers, 1)
+	results := make(chan fetchedPage, workers) // buffered, so workers never block on an abandoned crawl
+	fetched := make(map[int]fetchedPage)       // pages fetched ahead of those popped before them
+	popped, processed := 0, 0                  // pages are processed in the order they're popped, as if fetched one at a time
+	checkpointEvery := time.Duration(config.CheckpointEvery) * time.Second
+	lastCheckpoint := time.Now()
+	draining := false // a checkpoint is due, so no more URLs are popped until those in flight are done
+	traps := newTrapDetector(config, store)
+	followOutOfScope := c.crawlConfig.Scope != nil && c.crawlConfig.Scope.FollowOutOfScopeRedirects
+	var externalChecker *linkChecker
+	if config.CheckExternalLinks {
+		externalChecker = newExternalLinkChecker(c.client, c.linkCache, config.ExternalDelayMs)
+	}
 
-	// 3. crawl every URL in a queue
-	var URLQueue []QueueEntry
-	URLQueue = append(URLQueue, QueueEntry{root, 0})
-	visitedURLs[root] = true
+	// only HTML (and PDFs, if they're parsed) is downloaded. Everything else is recorded from its headers.
+	fetchOpts := fetchOptions{
+		MaxBodySize: int64(config.MaxBodyKB) * 1024,
+		ReadBody: func(contentType string) bool {
+			return isHTMLType(contentType) || (config.ParsePDFs && contentType == "application/pdf")
+		},
+	}
 
-	for len(URLQueue) > 0 {
+	// each host's robots.txt decides its crawl delay and which of its URLs are blocked. Only the root's is fetched upfront.
+	hostRobots := map[string]Robots{strings.ToLower(extractHost(root)): robots}
+	robotsFor := func(url string) Robots {
+		host := strings.ToLower(extractHost(url))
+		found, ok := hostRobots[host]
+		if !ok {
+			found, _ = getRobots(ctx, c.scheduled, url) // hosts without one have no rules
+			hostRobots[host] = found
+		}
+		return found
+	}
 
-		// a. pop
-		url := URLQueue[0].url
-		depth := URLQueue[0].crawlDepth
-		URLQueue = URLQueue[1:]
+	// returns the page popped seq-th, waiting for its worker
+	waitFor := func(seq int) fetchedPage {
+		for {
+			if page, ok := fetched[seq]; ok {
+				delete(fetched, seq)
+				return page
+			}
+			page := <-results
+			fetched[page.seq] = page
+		}
+	}
+	// puts the current page, and every page popped after it, back at the front of the queue in order, to refetch on resume
+	putBack := func(current QueueEntry) error {
+		for seq := popped - 1; seq >= processed; seq-- {
+			if err := store.PushFront(waitFor(seq).entry); err != nil {
+				return err
+			}
+		}
+		return store.PushFront(current)
+	}
 
-		isBlockedByRobots := isURLBlockedByRobots(url, robots)
+	// 2. crawl every URL in a queue
+	for {
 
-		// b. fetch (if allowed)
-		if !config.RespectRobots || !isBlockedByRobots {
-			html, status, redirectTo, err := fetchURL(url, config, robots)
+		// a. pop URLs for every free worker, which each wait for their host's turn (stopping if cancelled)
+		for popped-processed < workers && store.Queued() > 0 && ctx.Err() == nil && !draining {
+			entry, _, err := store.Pop()
 			if err != nil {
-				fmt.Println("[!] Error fetching URL: ", err)
 				return URLObjectList{}, err
 			}
+			entryRobots := robotsFor(entry.URL)
+			blocked, interval := isURLBlockedByRobots(entry.URL, entryRobots), crawlInterval(config, entryRobots)
+			go func(seq int) {
+				page := c.fetchEntry(ctx, scheduler, entry, interval, blocked, config, fetchOpts)
+				page.seq = seq
+				results <- page
+			}(popped)
+			popped++
+		}
+		if popped == processed {
+			break
+		}
 
-			indexable := false
-			canonical := ""
-			// meta
-			noIndex := false
-			metaDescription := ""
-			metaTitle := ""
-			// other html elements
-			h1 := ""
+		// b. take the next page in the order they were popped, putting it back in the queue if cancelled
+		page := waitFor(processed)
+		processed++
+		if ctx.Err() != nil {
+			if err := putBack(page.entry); err != nil {
+				return URLObjectList{}, err
+			}
+			return URLObjectList{}, ctx.Err()
+		}
+		entry := page.entry
+		url, depth := entry.URL, entry.CrawlDepth
+		isBlockedByRobots := page.blocked
 
-			// c. check for redirect status
+		// c. record what was fetched (if allowed)
+		if !config.RespectRobots || !isBlockedByRobots {
+			if page.err != nil {
+				c.logger.Printf("[!] Error fetching URL: %v", page.err)
+				return URLObjectList{}, page.err
+			}
+
+			status, redirectTo := 0, ""
+			var response *http.Response
+			var header http.Header
+			var body []byte
+			var meta ResponseMeta
+			if result := page.result; result != nil {
+				response, body, meta = result.Response, result.Body, result.Meta
+				status, redirectTo, header = response.StatusCode, redirectLocation(response), response.Header
+			}
+			scan, doc, visitors := page.scan, page.doc, page.visitors
+			isHTML := visitors != nil // only 200 HTML responses are scanned with them
+			var err error
+
+			// d. check for redirect status
 			if status >= 300 && status < 400 {
-				if redirectTo != "" && !visitedURLs[redirectTo] {
-					URLQueue = append(URLQueue, QueueEntry{redirectTo, depth})
-					visitedURLs[redirectTo] = true
+				isNew := false
+				if redirectTo != "" {
+					redirectTo = resolveReference(url, redirectTo)
+					if reason := outOfScopeReason(scope, redirectTo); reason != "" && !followOutOfScope {
+						if err := store.RecordOutOfScope(redirectTo, url, outOfScopeRedirect+": "+reason); err != nil {
+							return URLObjectList{}, err
+						}
+					} else if isNew, err = store.See(redirectTo); err != nil {
+						return URLObjectList{}, err
+					}
+				}
+				if isNew {
+					if err := store.Push(QueueEntry{URL: redirectTo, CrawlDepth: depth}); err != nil {
+						return URLObjectList{}, err
+					}
+					c.runURLDiscoveredHooks(redirectTo, url)
 					//fmt.Printf("> Redirect: %s → %s\n", url, redirectTo)
 				}
-			} else if status == 200 {
-				indexable, noIndex, canonical, metaDescription, metaTitle, h1 = parseHTML(html)
 			}
 
-			// d. collect every link on current URL
-			links := extractLinks(html)
+			// e. the page was parsed by its worker, once, for its links, meta and headings
+			links := scan.Links
+			followLinks := depth == 0 || scope.InScope(url) // not those of out-of-scope redirect targets
 
-			// e. add current URL results to URLObject
-			URLObjects[url] = &URLObject{Inlinks: 1, Outlinks: len(links), PageStatus: status, CrawlDepth: depth,
-				Indexability: indexable, NoIndex: noIndex, Canonical: canonical, IsBlockedByRobots: isBlockedByRobots,
-				MetaTitle: metaTitle, MetaTitleLength: len(metaTitle), MetaDescription: metaDescription, MetaDescriptionLength: len(metaDescription), H1: h1, H1Length: len(h1)}
+			// f. add current URL results to URLObject, note its external links, and run any plugins
+			obj := &URLObject{Inlinks: 1, Outlinks: len(links), PageStatus: status, CrawlDepth: depth, Trap: entry.Trap,
+				Indexability: scan.Indexable, NoIndex: scan.NoIndex, Canonical: scan.Canonical, IsBlockedByRobots: isBlockedByRobots,
+				MetaTitle: scan.MetaTitle, MetaTitleLength: len(scan.MetaTitle), MetaDescription: scan.MetaDescription, MetaDescriptionLength: len(scan.MetaDescription), H1: scan.H1, H1Length: len(scan.H1), Response: meta}
+
+			if externalChecker != nil && followLinks {
+				obj.ExternalLinks = findExternalLinks(url, root, links, scope)
+			}
+			c.runPageHooks(&Page{URL: url, Response: response, Header: header, Body: body, Doc: doc, Object: obj, isHTML: isHTML, visitors: visitors})
+			if ctx.Err() != nil {
+				// plugins cut short record wrong results (e.g. unreachable images), so the page is crawled again on resume
+				if err := putBack(entry); err != nil {
+					return URLObjectList{}, err
+				}
+				return URLObjectList{}, ctx.Err()
+			}
+			if err := store.Put(url, obj); err != nil {
+				return URLObjectList{}, err
+			}
 
-			// f. iterate through all links of current URL
+			// g. iterate through all links of current URL, unless it's an out-of-scope redirect target
+			tooDeep := config.MaxCrawlDepth > 0 && depth+1 > config.MaxCrawlDepth
+			if !followLinks {
+				links = nil
+			}
 			for _, link := range links {
 
 				// i. ignore 0-length URLs
+				link = strings.TrimSpace(link)
 				if len(link) == 0 {
 					continue
 				}
 
-				// ii. resolve relative URLs
-				if link[0] == '/' {
-					if root[len(root)-1] == '/' {
-						link = root[:len(root)-1] + link
-					} else {
-						link = root + link
-					}
+				// ii. resolve relative URLs against the page, dropping fragments (they're the same page)
+				link = resolveReference(url, link)
+				if i := strings.IndexByte(link, '#'); i >= 0 {
+					link = link[:i]
 				}
 
-				// iii. ignore external urls
-				if !rootRegex.MatchString(link) {
+				// iii. normalise URLs to WWW preference
+				link = normaliseWWW(link, root)
+
+				// iv. record URLs outside the crawl's scope (by default, external URLs) instead of following them
+				if reason := outOfScopeReason(scope, link); reason != "" {
+					if isWebURL(link) {
+						if err := store.RecordOutOfScope(link, url, reason); err != nil {
+							return URLObjectList{}, err
+						}
+					}
 					continue
 				}
 
-				// iv. normalise URLs to WWW preference
-				link = normaliseWWW(link, root)
-
-				// v. check if URL already processed, else add to queue (if not current URL)
-				if obj, ok := URLObjects[link]; ok {
+				// v. check if URL already processed, else add to queue (if not current URL or beyond max crawl depth)
+				obj, ok, err := store.Get(link)
+				if err != nil {
+					return URLObjectList{}, err
+				}
+				if ok {
 					obj.Inlinks++
-				} else if !visitedURLs[link] {
-					URLQueue = append(URLQueue, QueueEntry{link, depth + 1})
-					visitedURLs[link] = true
+					if err := store.Put(link, obj); err != nil {
+						return URLObjectList{}, err
+					}
+				} else if !tooDeep {
+					// vi. flag URLs that look like crawler traps, recording them instead of queueing them if SkipTraps
+					trap, err := traps.check(link, url)
+					if err != nil {
+						return URLObjectList{}, err
+					}
+					if trap != "" && config.SkipTraps {
+						if err := store.RecordOutOfScope(link, url, outOfScopeTrap+": "+trap); err != nil {
+							return URLObjectList{}, err
+						}
+						continue
+					}
+
+					if isNew, err := store.See(link); err != nil {
+						return URLObjectList{}, err
+					} else if isNew {
+						if err := store.Push(QueueEntry{URL: link, CrawlDepth: depth + 1, Trap: trap}); err != nil {
+							return URLObjectList{}, err
+						}
+						c.runURLDiscoveredHooks(link, url)
+					}
 				}
 			}
 		} else {
-			fmt.Printf("URL blocked by robots: %s\n", url) //debug
+			c.logger.Printf("URL blocked by robots: %s", url) //debug
+		}
+
+		// h. write the page's changes to the store, checkpointing every so often so a killed crawl can resume.
+		// Checkpoints wait for URLs in flight, which have left the queue but aren't yet recorded.
+		if err := store.Flush(); err != nil {
+			return URLObjectList{}, err
 		}
+		if checkpointEvery > 0 && time.Since(lastCheckpoint) >= checkpointEvery {
+			draining = popped > processed
+			if !draining {
+				if err := checkpoint(); err != nil {
+					c.logger.Printf("[!] Error saving checkpoint: %v", err)
+				}
+				lastCheckpoint = time.Now()
+			}
+		}
+	}
+	if ctx.Err() != nil && store.Queued() > 0 {
+		return URLObjectList{}, ctx.Err()
 	}
 
-	URLObjectList := URLObjectList{URLObjects: URLObjects}
+	// 3. check the external links found, now the site's pages are done, so slow external hosts don't hold up the crawl
+	if externalChecker != nil {
+		c.logger.Printf("(i) Checking external links")
+		if err := checkExternalLinks(ctx, externalChecker, store); err != nil {
+			return URLObjectList{}, err
+		}
+	}
+
+	URLObjectList := URLObjectList{Config: config, results: store}
+	if state, ok := store.(*crawlState); ok {
+		URLObjectList.URLObjects, URLObjectList.OutOfScope = state.URLObjects, state.OutOfScope // already in memory
+	}
 
 	return URLObjectList, nil
 }
 
-// Crawl all URLs on a site
-func GoWild(crawlConfig CrawlConfig, config ProgramConfig) (URLObjectList, error) {
+// Crawls the site, then runs any plugins' end hooks and exporters. Nothing is printed: progress goes to the crawler's logger.
+// If ctx is cancelled or its deadline passes, the crawl stops, is checkpointed (if storage is set) and ctx.Err() is returned.
+// If an exporter fails, the result is returned along with the error. Close the result once done with it.
+func (c *Crawler) Run(ctx context.Context) (*CrawlResult, error) {
 	start := time.Now()
-	root := crawlConfig.Root
-	fmt.Printf("= = = Starting new crawl of %s = = =\n", root)
+	root := c.crawlConfig.Root
+	c.logger.Printf("= = = Starting new crawl of %s = = =", root)
+
+	crawlID := c.crawlID
+	if crawlID == "" {
+		crawlID = checkpointKey(c.crawlConfig)
+	}
+	if err := validateCrawlID(crawlID); err != nil {
+		c.logger.Printf("[!] %v", err)
+		return nil, err
+	}
+	c.logger.Printf("(i) Crawl ID: %s", crawlID)
+
+	config := c.crawlConfig.EffectiveConfig(c.config)
+	printProgramConfig(c.logger, config)
+
+	if err := c.session.start(root, c.crawlConfig.Requests, config.SecretsFile); err != nil {
+		c.logger.Printf("[!] Failed to set up requests: %v", err)
+		return nil, err
+	}
+	if login := c.session.loginConfig; login != nil {
+		if err := c.session.login(ctx, c.scheduled); err != nil {
+			c.logger.Printf("[!] Failed to log in at %s: %v", login.URL, err)
+			return nil, err
+		}
+		c.logger.Printf("(i) Logged in at %s", login.URL)
+	}
 
 	// 1. detect and set preference for www or non www
-	root, err := setWWWPreference(root)
+	root, err := setWWWPreference(ctx, c.scheduled, root)
 	if err != nil {
-		fmt.Println("[!] Error detecting www preference:", err)
-		return URLObjectList{}, err
+		c.logger.Printf("[!] Error detecting www preference: %v", err)
+		return nil, err
+	}
+	if root != c.crawlConfig.Root {
+		c.logger.Printf("(i) Detected www preference: %s -> %s", c.crawlConfig.Root, root)
 	}
-	fmt.Println("(i) Normalising all URLs to:", root) //debug
+	c.logger.Printf("(i) Normalising all URLs to: %s", root) //debug
 
 	// 2. Get robots
-	robots, err := getRobots(root)
+	robots, err := getRobots(ctx, c.scheduled, root)
 	if err != nil {
-		fmt.Println(err)
+		c.logger.Printf("[!] %v", err)
 	} else {
-		printSiteMap(robots)
+		c.logger.Printf("(i) Found robots file for %s", root)
+		printSiteMap(c.logger, robots)
 	}
 
-	// 2. Crawl site
-	objectList, err := crawl(root, config, robots)
+	scope := c.scope
+	if scope == nil {
+		configScope, err := c.crawlConfig.Scope.compile(root)
+		if err != nil {
+			c.logger.Printf("[!] Invalid crawl scope: %v", err)
+			return nil, err
+		}
+		scope = configScope
+	}
+	c.session.setScope(root, scope)
+	storage := c.storage
+	if storage == nil && config.CheckpointDir != "" {
+		storage = DirStorage(config.CheckpointDir)
+	}
+
+	if err := c.runCrawlStartHooks(ctx, root); err != nil {
+		c.logger.Printf("[!] Failed to start crawl: %v", err)
+		return nil, err
+	}
+
+	// 3. Crawl site, resuming from any checkpoint of the same crawl ID
+	checkpoints, err := openCheckpointer(crawlID, root, config, storage, c.logger)
 	if err != nil {
-		fmt.Println("[!] Failed to crawl root: ", err)
-		return URLObjectList{}, err
+		c.logger.Printf("[!] %v", err)
+		return nil, err
 	}
+	start = checkpoints.started()
 
-	// 3. Calculate post-crawl metrics for each URLObject
-	objectList.runPostCrawl()
+	objectList, err := c.crawl(ctx, checkpoints.store(), root, config, robots, scope, checkpoints.save)
+	if err != nil && ctx.Err() != nil {
+		store := checkpoints.store()
+		if err := checkpoints.interrupt(); err != nil {
+			c.logger.Printf("[!] Error saving checkpoint: %v", err)
+		} else {
+			c.logger.Printf("(i) Crawl %s of %s interrupted. Checkpointed %d URLs crawled, %d queued", crawlID, root, store.Crawled(), store.Queued())
+		}
+	} else if err != nil {
+		if finishErr := checkpoints.finish(false); finishErr != nil {
+			c.logger.Printf("[!] %v", finishErr)
+		}
+	}
+	if err != nil {
+		c.logger.Printf("[!] Failed to crawl root: %v", err)
+		return nil, err
+	}
+	// disk stores stay open for the results to be read from until the list is closed, which removes the checkpoint
+	objectList.close = sync.OnceValue(func() error { return checkpoints.finish(true) })
+	if checkpoints.disk == nil {
+		if err := objectList.Close(); err != nil {
+			c.logger.Printf("[!] %v", err)
+		}
+	}
+
+	// 4. Calculate post-crawl metrics for each URLObject
+	if err := objectList.runPostCrawl(); err != nil {
+		c.logger.Printf("[!] Failed to run post-crawl metrics: %v", err)
+		objectList.Close()
+		return nil, err
+	}
+	analysis, err := AnalyseCrawl(objectList)
+	if err != nil {
+		c.logger.Printf("[!] Failed to analyse crawl: %v", err)
+		objectList.Close()
+		return nil, err
+	}
+
+	result := &CrawlResult{URLObjectList: objectList, Analysis: analysis, CrawlID: crawlID, Root: root, Robots: robots, Started: start, Duration: time.Since(start)}
+
+	c.logger.Printf("Successfully crawled %s", root)
+	c.logger.Printf(" ↳ Total URLs crawled: %d", objectList.Len())
+	c.logger.Printf(" ↳ Total crawl time: %s", result.Duration)
+
+	// 5. Finish plugins, then export
+	errs := c.runCrawlEndHooks(ctx, result)
+	for _, exporter := range c.exporters {
+		if err := exporter.Export(ctx, result); err != nil {
+			c.logger.Printf("[!] Error exporting crawl: %v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return result, errors.Join(errs...)
+}
 
-	// 4. Return (and print) results
-	/*
-		for key, value := range URLObjects {
-			fmt.Printf("URL: %s\n ↳ Inlinks: %d | pageStatus: %d | outlinks: %d | crawl depth: %d | indexable: %v | canonical: %s\n",
-				key, value.Inlinks, value.PageStatus, value.Outlinks, value.CrawlDepth, value.Indexability, value.Canonical)
+// Crawl all URLs on a site, using the program config with any of the site's overrides applied. Close the list once done with it.
+func GoWild(crawlConfig CrawlConfig, config ProgramConfig) (URLObjectList, error) {
+	return GoWildContext(context.Background(), crawlConfig, config)
+}
+
+// As GoWild, but stops early if ctx is cancelled. The interrupted crawl is checkpointed to config.CheckpointDir
+// (if set), and the site's next crawl resumes from there. Progress is logged to stdout, and any opts are applied last.
+func GoWildContext(ctx context.Context, crawlConfig CrawlConfig, config ProgramConfig, opts ...Option) (URLObjectList, error) {
+	crawler := NewCrawler(crawlConfig, append([]Option{WithProgramConfig(config), WithLogger(log.New(os.Stdout, "", 0))}, opts...)...)
+
+	result, err := crawler.Run(ctx)
+	if err != nil {
+		if result != nil {
+			result.Close()
 		}
-	*/
-	fmt.Printf("Successfully crawled %s\n", root)
-	fmt.Printf(" ↳ Total URLs crawled: %d\n", len(objectList.URLObjects))
-	fmt.Printf(" ↳ Total crawl time: %s\n", time.Since(start))
+		return URLObjectList{}, err
+	}
 
-	return objectList, nil
+	return result.URLObjectList, nil
 }
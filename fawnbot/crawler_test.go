@@ -0,0 +1,49 @@
+package fawnbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// links are resolved against the page they're on, so scope is decided on the URL they lead to
+func TestCrawlResolvesRelativeLinks(t *testing.T) {
+	pages := map[string]string{
+		"/":                `<a href="docs/intro.html">document-relative</a> <a href="//other.example/x">protocol-relative</a> <a href="#top">fragment</a>`,
+		"/docs/intro.html": `<a href="../about"> parent </a> <a href="/docs/intro.html#install">absolute, with a fragment</a>`,
+		"/about":           `<a href="?page=2">query</a>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	config := DefaultProgramConfig()
+	config.CheckpointDir = ""
+	result, err := NewCrawler(CrawlConfig{Root: server.URL + "/"}, WithProgramConfig(config), WithRateLimit(100)).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	want := []string{"/", "/docs/intro.html", "/about", "/about?page=2"}
+	if len(result.URLObjects) != len(want) {
+		t.Errorf("crawled %d URLs, want %d: %v", len(result.URLObjects), len(want), result.URLObjects)
+	}
+	for _, path := range want {
+		if _, ok := result.URLObjects[server.URL+path]; !ok {
+			t.Errorf("%s wasn't crawled", path)
+		}
+	}
+	if _, ok := result.OutOfScope["http://other.example/x"]; !ok || len(result.OutOfScope) != 1 {
+		t.Errorf("out of scope %v, want only http://other.example/x", result.OutOfScope)
+	}
+}
@@ -1,6 +1,9 @@
 package fawnbot
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+)
 
 func goTame() {
 	// substitutes a crawl (so I don't recrawl every time)
@@ -24,17 +27,17 @@ func goTame() {
 	}*/
 }
 
-func printSiteMap(robots Robots) {
-	fmt.Println("(i) Debug - logging robots file:")
-	fmt.Println(">   Agents:")
+func printSiteMap(logger Logger, robots Robots) {
+	logger.Printf("(i) Debug - logging robots file:")
+	logger.Printf(">   Agents:")
 	for _, agent := range robots.Agents {
-		fmt.Printf(">   Agent name: %s\n>   Agent disallows: %v\n>   Agent allows: %v\n", agent.Name, agent.Disallow, agent.Allow)
+		logger.Printf(">   Agent name: %s\n>   Agent disallows: %v\n>   Agent allows: %v", agent.Name, agent.Disallow, agent.Allow)
 	}
-	fmt.Println(">   Sitemaps:")
+	logger.Printf(">   Sitemaps:")
 	for _, sitemap := range robots.Sitemaps {
-		fmt.Println(">   ", sitemap)
+		logger.Printf(">    %s", sitemap)
 	}
-	fmt.Printf(">   CrawlDelay: %d\n", robots.CrawlDelay)
+	logger.Printf(">   CrawlDelay: %g", robots.CrawlDelay)
 }
 
 func printCrawlConfig(crawlConfig CrawlConfig) {
@@ -42,9 +45,18 @@ func printCrawlConfig(crawlConfig CrawlConfig) {
 	fmt.Printf("     Root: %s\n", crawlConfig.Root)
 	fmt.Printf("     Start: %s\n", crawlConfig.CrawlStart)
 	fmt.Printf("     Frequency: %s\n", crawlConfig.CrawlFrequency)
+	fmt.Printf("     Timezone: %s\n", crawlConfig.Timezone)
 	fmt.Printf("     KeepOldCrawls: %t\n", crawlConfig.KeepOldCrawls)
 }
 
+func printProgramConfig(logger Logger, config ProgramConfig) {
+	logger.Printf("(i) Effective crawl settings:")
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		logger.Printf("     %s: %v", v.Type().Field(i).Name, v.Field(i).Interface())
+	}
+}
+
 func VerifyModuleImport() {
 	fmt.Println("Successfully accessed function in wildfawn module")
 }
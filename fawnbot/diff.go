@@ -0,0 +1,63 @@
+package fawnbot
+
+/*
+| - - diff.go - -
+| Compares two crawls of the same site
+*/
+
+import (
+	"reflect"
+	"sort"
+)
+
+type CrawlDiff struct {
+	Added   []string    // URLs only in the later crawl
+	Removed []string    // URLs only in the earlier crawl
+	Changed []URLChange // metrics that differ for URLs in both
+}
+
+type URLChange struct {
+	URL    string
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+func (d CrawlDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compares every URLObject field between two crawls, except those tagged diff:"-". Results are sorted by URL.
+func DiffCrawls(before, after URLObjectList) CrawlDiff {
+	var diff CrawlDiff
+
+	for _, url := range sortedURLs(before.URLObjects) {
+		if _, ok := after.URLObjects[url]; !ok {
+			diff.Removed = append(diff.Removed, url)
+		}
+	}
+
+	for _, url := range sortedURLs(after.URLObjects) {
+		beforeObj, ok := before.URLObjects[url]
+		if !ok {
+			diff.Added = append(diff.Added, url)
+			continue
+		}
+
+		beforeValue := reflect.ValueOf(*beforeObj)
+		afterValue := reflect.ValueOf(*after.URLObjects[url])
+		for i := 0; i < beforeValue.NumField(); i++ {
+			if beforeValue.Type().Field(i).Tag.Get("diff") == "-" {
+				continue
+			}
+			b, a := beforeValue.Field(i).Interface(), afterValue.Field(i).Interface()
+			if !reflect.DeepEqual(b, a) {
+				diff.Changed = append(diff.Changed, URLChange{URL: url, Field: beforeValue.Type().Field(i).Name, Before: b, After: a})
+			}
+		}
+	}
+
+	sort.SliceStable(diff.Changed, func(i, j int) bool { return diff.Changed[i].URL < diff.Changed[j].URL })
+
+	return diff
+}
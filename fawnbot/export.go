@@ -3,14 +3,23 @@ package fawnbot
 /*
 | - - export.go - -
 | Contains functionality for post-crawl data exports to:
-| - Excel
-| - General-purpose JSON export (WIP)
+| - Google Sheets
+| - CSV
+| - General-purpose JSON export
 */
 
 import (
+	"bufio"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2/google"
@@ -54,13 +63,13 @@ func sheetExists(service *sheets.Service, sheetID string, sheetName string) (boo
 	return false, nil
 }
 
-func createNewSheet(service *sheets.Service, sheetID string, sheetName string) (int64, error) {
+func createNewSheet(logger Logger, service *sheets.Service, sheetID string, sheetName string) (int64, error) {
 	exists, err := sheetExists(service, sheetID, sheetName)
 	if err != nil {
 		return 0, fmt.Errorf("error checking sheet existence: %v", err)
 	}
 	if exists {
-		fmt.Printf("(i) Sheet '%s' already exists, skipping creation.\n", sheetName)
+		logger.Printf("(i) Sheet '%s' already exists, skipping creation.", sheetName)
 		return 0, nil
 	}
 
@@ -85,58 +94,259 @@ func createNewSheet(service *sheets.Service, sheetID string, sheetName string) (
 	return sheetIDNum, nil
 }
 
-func writeCrawlToSheet(service *sheets.Service, sheetID string, sheetName string, URLObjectList URLObjectList) error {
+const sheetRowBatch = 1000 // rows written to a sheet per request
+
+func writeCrawlToSheet(logger Logger, service *sheets.Service, sheetID string, sheetName string, URLObjectList URLObjectList) error {
 	var err error
-	data := URLObjectList.URLObjects
 	start := time.Now()
-	fmt.Println("(i) Writing Crawl...")
+	logger.Printf("(i) Writing Crawl...")
 
-	// Convert URLObject to interface for Sheets
-	var values [][]interface{}
-	values = append(values, []interface{}{
+	// Clear any existing data on sheet, however many rows and columns the last crawl wrote
+	_, err = service.Spreadsheets.Values.Clear(sheetID, sheetName, &sheets.ClearValuesRequest{}).Do()
+	if err != nil {
+		return fmt.Errorf("failed to clear sheet before writing: %v", err)
+	}
+
+	// Write data to the sheet in batches, so only a batch of rows is in memory at once
+	var batch [][]interface{}
+	written := 0
+	writeBatch := func() error {
+		writeRange := fmt.Sprintf("%s!A%d", sheetName, written+1) // e.g. Sheet1!A1
+		_, err := service.Spreadsheets.Values.Update(sheetID, writeRange, &sheets.ValueRange{Values: batch}).ValueInputOption("RAW").Do()
+		if err != nil {
+			return fmt.Errorf("failed to write data to sheet: %v", err)
+		}
+		written += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	err = forEachCrawlRow(URLObjectList, func(row []interface{}) error {
+		if batch = append(batch, row); len(batch) == sheetRowBatch {
+			return writeBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := writeBatch(); err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("(i) Data successfully written to %s in %s", sheetName, time.Since(start))
+
+	return nil
+}
+
+// the custom columns of a crawl's export, after its built-in metrics
+type crawlColumns struct {
+	extracted []string
+	searched  []string
+	columns   []string // added by plugins
+}
+
+// returns the names of every custom column used in a crawl, sorted
+func crawlColumnsOf(URLObjectList URLObjectList) (crawlColumns, error) {
+	extracted, searched, columns := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		addKeys(extracted, obj.Extracted)
+		addKeys(searched, obj.Search)
+		addKeys(columns, obj.Columns)
+		return nil
+	})
+	return crawlColumns{sortedKeys(extracted), sortedKeys(searched), sortedKeys(columns)}, err
+}
+
+func (c crawlColumns) header() []interface{} {
+	headers := []interface{}{
 		"URL", "Inlinks", "Outlinks", "Page Status", "Crawl Depth",
 		"No Index", "Indexability", "Canonical", "Self-Canonicalises", "Is Canonical Indexable",
 		"Is Orphan", "Blocked by Robots",
-		"Meta Title", "Meta Title Length", "Meta Description", "Meta Description Length", "H1", "H1 Length"}) //headers
+		"Meta Title", "Meta Title Length", "Meta Description", "Meta Description Length", "H1", "H1 Length",
+		"Structured Data Types", "Structured Data Problems",
+		"OG Title", "OG Image", "OG URL", "Twitter Card", "Social Problems",
+		"Hreflang", "Hreflang Problems", "Images", "Images Missing Alt",
+		"Resources", "Broken Resources", "External Links", "Broken External Links",
+		"TTFB (ms)", "Download Time (ms)", "Size (Bytes)", "Transfer Size (Bytes)", "Body Read", "Content Type", "Charset", "Content Encoding",
+		"Last Modified", "ETag", "Cache-Control", "Server IP", "Trap"}
+	for _, name := range c.extracted {
+		headers = append(headers, "Extract: "+name)
+	}
+	for _, name := range c.searched {
+		headers = append(headers, "Search: "+name)
+	}
+	for _, column := range c.columns {
+		headers = append(headers, column)
+	}
+	return append(headers, "Issues")
+}
 
-	for url, obj := range data {
-		row := []interface{}{
-			url, obj.Inlinks, obj.Outlinks, obj.PageStatus, obj.CrawlDepth,
-			obj.NoIndex, obj.Indexability, obj.Canonical, obj.IsSelfCanonicalising, obj.IsCanonicalIndexable,
-			obj.IsOrphan, obj.IsBlockedByRobots,
-			obj.MetaTitle, obj.MetaTitleLength, obj.MetaDescription, obj.MetaDescriptionLength, obj.H1, obj.H1Length}
-		values = append(values, row)
+func (c crawlColumns) row(url string, obj *URLObject) []interface{} {
+	row := []interface{}{
+		url, obj.Inlinks, obj.Outlinks, obj.PageStatus, obj.CrawlDepth,
+		obj.NoIndex, obj.Indexability, obj.Canonical, obj.IsSelfCanonicalising, obj.IsCanonicalIndexable,
+		obj.IsOrphan, obj.IsBlockedByRobots,
+		obj.MetaTitle, obj.MetaTitleLength, obj.MetaDescription, obj.MetaDescriptionLength, obj.H1, obj.H1Length,
+		strings.Join(structuredDataTypes(obj.StructuredData), ", "), joinIssues(structuredDataProblems(obj.StructuredData)),
+		obj.OpenGraph["og:title"], obj.OpenGraph["og:image"], obj.OpenGraph["og:url"], obj.TwitterCard["twitter:card"], joinIssues(obj.SocialProblems),
+		len(obj.Hreflang), joinIssues(obj.HreflangProblems), len(obj.Images), imagesMissingAlt(obj.Images),
+		len(obj.Resources), brokenResources(obj.Resources), len(obj.ExternalLinks), brokenExternalLinks(obj.ExternalLinks),
+		obj.Response.TTFB.Milliseconds(), obj.Response.DownloadTime.Milliseconds(), sizeValue(obj.Response.Size), sizeValue(obj.Response.TransferSize), obj.Response.bodyRead(), obj.Response.ContentType, obj.Response.Charset, obj.Response.ContentEncoding,
+		obj.Response.LastModified, obj.Response.ETag, obj.Response.CacheControl, obj.Response.ServerIP, obj.Trap}
+	for _, name := range c.extracted {
+		row = append(row, obj.Extracted[name])
+	}
+	for _, name := range c.searched {
+		if match, ok := obj.Search[name]; ok {
+			row = append(row, match.Count)
+		} else {
+			row = append(row, "")
+		}
+	}
+	for _, column := range c.columns {
+		row = append(row, obj.Columns[column])
 	}
+	return append(row, joinIssues(obj.Issues))
+}
 
-	// Define write range of sheet (e.g., "Sheet1!A1")
-	writeRange := fmt.Sprintf("%s!A1", sheetName)
+// calls each with a header row, then one row per URL (sorted by URL), reading the results one at a time.
+// Shared by every crawl exporter.
+func forEachCrawlRow(URLObjectList URLObjectList, each func(row []interface{}) error) error {
+	columns, err := crawlColumnsOf(URLObjectList)
+	if err != nil {
+		return err
+	}
+	if err := each(columns.header()); err != nil {
+		return err
+	}
+	return URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		return each(columns.row(url, obj))
+	})
+}
 
-	// Prepare value range
-	valueRange := &sheets.ValueRange{
-		Values: values,
+func sortedURLs(URLObjects map[string]*URLObject) []string {
+	return sortedKeys(URLObjects)
+}
+
+// Writes a crawl as CSV, with the same columns as the Sheets export
+func WriteCrawlCSV(w io.Writer, URLObjectList URLObjectList) error {
+	rows := newCSVRows(w)
+	if err := forEachCrawlRow(URLObjectList, func(row []interface{}) error { return rows.write(row...) }); err != nil {
+		return err
 	}
+	return rows.flush()
+}
 
-	// Clear any existing data on sheet
-	clearRange := fmt.Sprintf("%s!A1:Z1000", sheetName)
-	_, err = service.Spreadsheets.Values.Clear(sheetID, clearRange, &sheets.ClearValuesRequest{}).Do()
-	if err != nil {
-		return fmt.Errorf("failed to clear sheet before writing: %v", err)
+func writeCSV(w io.Writer, rows [][]interface{}) error {
+	writer := newCSVRows(w)
+	for _, row := range rows {
+		if err := writer.write(row...); err != nil {
+			return err
+		}
 	}
+	return writer.flush()
+}
 
-	// Write data to the sheet
-	_, err = service.Spreadsheets.Values.Update(sheetID, writeRange, valueRange).ValueInputOption("RAW").Do()
-	if err != nil {
-		return fmt.Errorf("failed to write data to sheet: %v", err)
+// csvRows writes CSV a row at a time, so exports don't need a whole crawl in memory
+type csvRows struct {
+	writer *csv.Writer
+}
+
+func newCSVRows(w io.Writer) *csvRows {
+	return &csvRows{writer: csv.NewWriter(w)}
+}
+
+func (r *csvRows) write(row ...interface{}) error {
+	record := make([]string, len(row))
+	for i, cell := range row {
+		record[i] = fmt.Sprint(cell)
+	}
+	if err := r.writer.Write(record); err != nil {
+		return fmt.Errorf("failed to write CSV: %v", err)
 	}
+	return nil
+}
 
-	fmt.Printf("(i) Data successfully written to %s in %s\n", sheetName, time.Since(start))
+func (r *csvRows) flush() error {
+	r.writer.Flush()
+	return r.writer.Error()
+}
 
+// Writes a crawl (URL data and the settings it ran with) as JSON, readable again with ReadCrawlJSON.
+// URLObjects and out-of-scope URLs are written one at a time, as they're read from the crawl's store.
+func WriteCrawlJSON(w io.Writer, URLObjectList URLObjectList) error {
+	buffered := bufio.NewWriter(w)
+	// writes v as indented JSON nested at the given depth, as json.Encoder would
+	encode := func(v interface{}, depth int) error {
+		data, err := json.MarshalIndent(v, strings.Repeat("  ", depth), "  ")
+		if err != nil {
+			return fmt.Errorf("failed to write JSON: %v", err)
+		}
+		buffered.Write(data)
+		return nil
+	}
+
+	buffered.WriteString("{\n  \"URLObjects\": {")
+	first := true
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		if !first {
+			buffered.WriteString(",")
+		}
+		first = false
+		buffered.WriteString("\n    ")
+		if err := encode(url, 2); err != nil {
+			return err
+		}
+		buffered.WriteString(": ")
+		return encode(obj, 2)
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		buffered.WriteString("\n  ")
+	}
+	buffered.WriteString("}")
+
+	// omitted if there are none, as in URLObjectList's JSON
+	first = true
+	err = URLObjectList.ForEachOutOfScope(func(url string, found *OutOfScopeURL) error {
+		if first {
+			buffered.WriteString(",\n  \"OutOfScope\": {")
+		} else {
+			buffered.WriteString(",")
+		}
+		first = false
+		buffered.WriteString("\n    ")
+		if err := encode(url, 2); err != nil {
+			return err
+		}
+		buffered.WriteString(": ")
+		return encode(found, 2)
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		buffered.WriteString("\n  }")
+	}
+	buffered.WriteString(",\n  \"Config\": ")
+	if err := encode(URLObjectList.Config, 1); err != nil {
+		return err
+	}
+	buffered.WriteString("\n}\n")
+
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write JSON: %v", err)
+	}
 	return nil
 }
 
-func writeAnalysis(service *sheets.Service, analysis CrawlAnalysis, crawlConfig CrawlConfig) error {
+func writeAnalysis(logger Logger, service *sheets.Service, analysis CrawlAnalysis, crawlConfig CrawlConfig) error {
 	start := time.Now()
-	fmt.Println("(i) Writing Analysis...")
+	logger.Printf("(i) Writing Analysis...")
 
 	// check if sheet exists
 	exists, err := sheetExists(service, crawlConfig.SheetID, crawlConfig.AnalysisSheetName)
@@ -144,11 +354,11 @@ func writeAnalysis(service *sheets.Service, analysis CrawlAnalysis, crawlConfig
 		return fmt.Errorf("failed to verify if analysis sheet exists: %v", err)
 	}
 	if !exists {
-		_, err := createNewSheet(service, crawlConfig.SheetID, crawlConfig.AnalysisSheetName)
+		_, err := createNewSheet(logger, service, crawlConfig.SheetID, crawlConfig.AnalysisSheetName)
 		if err != nil {
 			return fmt.Errorf("failed to create analysis sheet: %v", err)
 		}
-		fmt.Printf("(i) Created new analysis sheet: %s\n", crawlConfig.AnalysisSheetName)
+		logger.Printf("(i) Created new analysis sheet: %s", crawlConfig.AnalysisSheetName)
 	}
 
 	// Find first free row
@@ -160,19 +370,33 @@ func writeAnalysis(service *sheets.Service, analysis CrawlAnalysis, crawlConfig
 
 	firstFreeRow := len(resp.Values) + 1
 
-	var values [][]interface{}
-
-	if firstFreeRow == 1 {
-		values = append(values, []interface{}{
-			"Crawl Date", "Internal URLs", "200s", "300s", "400s", "500s",
-			"Empty Meta Titles", "Empty Meta Descriptions", "Missing Canonicals", "No Indexes", "URLs Not In Sitemaps", "Non-Indexable URLs In Sitemaps", "Orphan URLs"})
+	// Read existing headers, so columns added since (e.g. new search rules) are appended rather than misaligned
+	var existing []interface{}
+	if firstFreeRow > 1 {
+		headerResp, err := service.Spreadsheets.Values.Get(crawlConfig.SheetID, fmt.Sprintf("%s!1:1", crawlConfig.AnalysisSheetName)).Do()
+		if err != nil {
+			return fmt.Errorf("failed to read analysis headers: %v", err)
+		}
+		if len(headerResp.Values) > 0 {
+			existing = headerResp.Values[0]
+		}
 	}
 
 	today := time.Now().Format("2006-01-02")
+	headers, row := analysisRow(today, analysis)
+	headers, row = alignRow(existing, headers, row)
 
-	values = append(values, []interface{}{
-		today, analysis.TotalInternalURLs, analysis.Total200s, analysis.Total300s, analysis.Total400s, analysis.Total500s,
-		analysis.TotalEmptyMetaTitles, analysis.TotalEmptyMetaDescriptions, analysis.TotalMissingCanonicals, analysis.TotalNoIndexes, analysis.TotalNotInSitemap, analysis.TotalNonIndexableInSitemap, analysis.TotalOrphans})
+	var values [][]interface{}
+	if firstFreeRow == 1 {
+		values = append(values, headers)
+	} else if len(headers) > len(existing) {
+		headerRange := fmt.Sprintf("%s!A1", crawlConfig.AnalysisSheetName)
+		_, err = service.Spreadsheets.Values.Update(crawlConfig.SheetID, headerRange, &sheets.ValueRange{Values: [][]interface{}{headers}}).ValueInputOption("RAW").Do()
+		if err != nil {
+			return fmt.Errorf("failed to add analysis headers: %v", err)
+		}
+	}
+	values = append(values, row)
 
 	writeRange := fmt.Sprintf("%s!A%d", crawlConfig.AnalysisSheetName, firstFreeRow)
 
@@ -186,33 +410,128 @@ func writeAnalysis(service *sheets.Service, analysis CrawlAnalysis, crawlConfig
 		return fmt.Errorf("failed to write data to sheet: %v", err)
 	}
 
-	fmt.Printf("(i) Data successfully written to Analysis sheet in %s.\n", time.Since(start))
+	logger.Printf("(i) Data successfully written to Analysis sheet in %s.", time.Since(start))
 
 	return nil
 }
 
-func WriteWild(URLObjectList URLObjectList, analysis CrawlAnalysis, crawlConfig CrawlConfig) {
+// returns the analysis sheet's headers and a crawl's row
+func analysisRow(date string, analysis CrawlAnalysis) ([]interface{}, []interface{}) {
+	headers := []interface{}{
+		"Crawl Date", "Internal URLs", "200s", "300s", "400s", "500s",
+		"Empty Meta Titles", "Empty Meta Descriptions", "Missing Canonicals", "No Indexes", "URLs Not In Sitemaps", "Non-Indexable URLs In Sitemaps", "Orphan URLs",
+		"Structured Data Problems", "Missing OG Titles", "Missing OG Images", "Missing OG URLs", "OG URL Mismatches", "Broken OG Images",
+		"Hreflang Problems", "Images", "Images Missing Alt", "Broken Images", "Oversized Images",
+		"Resources", "Broken Resources", "Blocked Resources", "Pages With Broken Resources",
+		"External Links", "Broken External Links", "Pages With Broken External Links",
+		"Average TTFB (ms)", "Slowest Pages", "Largest Pages", "Non-HTML On HTML URLs", "Out-Of-Scope URLs", "Trap URLs"}
+	row := []interface{}{
+		date, analysis.TotalInternalURLs, analysis.Total200s, analysis.Total300s, analysis.Total400s, analysis.Total500s,
+		analysis.TotalEmptyMetaTitles, analysis.TotalEmptyMetaDescriptions, analysis.TotalMissingCanonicals, analysis.TotalNoIndexes, analysis.TotalNotInSitemap, analysis.TotalNonIndexableInSitemap, analysis.TotalOrphans,
+		analysis.TotalStructuredDataProblems, analysis.TotalMissingOGTitles, analysis.TotalMissingOGImages, analysis.TotalMissingOGURLs, analysis.TotalOGURLMismatches, analysis.TotalBrokenOGImages,
+		analysis.TotalHreflangProblems, analysis.TotalImages, analysis.TotalImagesMissingAlt, analysis.TotalBrokenImages, analysis.TotalOversizedImages,
+		analysis.TotalResources, analysis.TotalBrokenResources, analysis.TotalBlockedResources, analysis.TotalPagesBrokenResources,
+		analysis.TotalExternalLinks, analysis.TotalBrokenExternalLinks, analysis.TotalPagesBrokenExternal,
+		analysis.AverageTTFBMs, rankedList(analysis.SlowestPages, "ms"), rankedList(analysis.LargestPages, "bytes"), analysis.TotalNonHTMLOnHTMLURLs, analysis.TotalOutOfScopeURLs, analysis.TotalTrapURLs}
+
+	for _, name := range sortedKeys(analysis.StructuredDataTypes) {
+		headers = append(headers, "Structured Data: "+name)
+		row = append(row, analysis.StructuredDataTypes[name])
+	}
+
+	for _, name := range sortedKeys(analysis.SearchResults) {
+		headers = append(headers, "Search: "+name)
+		row = append(row, analysis.SearchResults[name])
+	}
+
+	for _, trap := range sortedKeys(analysis.Traps) {
+		headers = append(headers, "Trap: "+trap)
+		row = append(row, analysis.Traps[trap])
+	}
+
+	return headers, row
+}
+
+// lists ranked URLs in one cell, e.g. "https://example.com/ (1200 ms) | https://example.com/blog/ (900 ms)"
+func rankedList(ranked []RankedURL, unit string) string {
+	items := make([]string, len(ranked))
+	for i, r := range ranked {
+		items[i] = fmt.Sprintf("%s (%d %s)", r.URL, r.Value, unit)
+	}
+	return strings.Join(items, " | ")
+}
+
+// reorders a row to match a sheet's existing headers, returning those headers with any new ones appended
+func alignRow(existing, headers, row []interface{}) ([]interface{}, []interface{}) {
+	merged := append([]interface{}{}, existing...)
+	aligned := make([]interface{}, len(existing))
+	for i := range aligned {
+		aligned[i] = ""
+	}
+
+	for i, header := range headers {
+		index := -1
+		for j, name := range merged {
+			if fmt.Sprint(name) == fmt.Sprint(header) {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			merged = append(merged, header)
+			aligned = append(aligned, row[i])
+		} else {
+			aligned[index] = row[i]
+		}
+	}
+
+	return merged, aligned
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Writes the analysis and crawl to the crawl config's spreadsheet, logging progress and errors to stdout.
+// Returns every error hit along the way.
+func WriteWild(URLObjectList URLObjectList, analysis CrawlAnalysis, crawlConfig CrawlConfig) error {
+	return writeWild(log.New(os.Stdout, "", 0), URLObjectList, analysis, crawlConfig)
+}
+
+// returns every error hit along the way. Each step is attempted even if an earlier one fails.
+func writeWild(logger Logger, URLObjectList URLObjectList, analysis CrawlAnalysis, crawlConfig CrawlConfig) error {
 	var err error
+	var errs []error
+	logError := func(message string, err error) {
+		logger.Printf("[!] %s %v", message, err)
+		errs = append(errs, fmt.Errorf("%s %v", strings.ToLower(message), err))
+	}
 
 	// Establish new service
 	service, err := startNewSheetsService()
 	if err != nil {
-		fmt.Println("[!] Could not create Sheets service:", err)
+		logError("Could not create Sheets service:", err)
+		return errors.Join(errs...)
 	}
 
 	// Write analysis
-	if err = writeAnalysis(service, analysis, crawlConfig); err != nil {
-		fmt.Println("[!] Error writing crawl analysis:", err)
+	if err = writeAnalysis(logger, service, analysis, crawlConfig); err != nil {
+		logError("Error writing crawl analysis:", err)
 	}
 
 	// Write crawl
-	_, err = createNewSheet(service, crawlConfig.SheetID, crawlConfig.SheetName)
+	_, err = createNewSheet(logger, service, crawlConfig.SheetID, crawlConfig.SheetName)
 	if err != nil {
-		fmt.Println("[!] Error creating new sheet:", err)
+		logError("Error creating new sheet:", err)
 	}
 
-	if err := writeCrawlToSheet(service, crawlConfig.SheetID, crawlConfig.SheetName, URLObjectList); err != nil {
-		fmt.Println("[!] Error writing to sheet:", err)
+	if err := writeCrawlToSheet(logger, service, crawlConfig.SheetID, crawlConfig.SheetName, URLObjectList); err != nil {
+		logError("Error writing to sheet:", err)
 	}
 
 	// Export copy of crawl
@@ -221,13 +540,54 @@ func WriteWild(URLObjectList URLObjectList, analysis CrawlAnalysis, crawlConfig
 		timestamp := time.Now().Format("2006-01-02")
 		newSheetName := fmt.Sprintf("Crawl %s", timestamp)
 
-		_, err = createNewSheet(service, crawlConfig.SheetID, newSheetName)
+		_, err = createNewSheet(logger, service, crawlConfig.SheetID, newSheetName)
 		if err != nil {
-			fmt.Println("[!] Error creating new sheet:", err)
+			logError("Error creating new sheet:", err)
 		}
 
-		if err := writeCrawlToSheet(service, crawlConfig.SheetID, newSheetName, URLObjectList); err != nil {
-			fmt.Println("[!] Error writing to sheet:", err)
+		if err := writeCrawlToSheet(logger, service, crawlConfig.SheetID, newSheetName, URLObjectList); err != nil {
+			logError("Error writing to sheet:", err)
 		}
 	}
+
+	return errors.Join(errs...)
+}
+
+// - - - exporters
+
+// Exporter receives a crawl's result once it finishes (see WithExporters)
+type Exporter interface {
+	Export(ctx context.Context, result *CrawlResult) error
+}
+
+// Writes the crawl and analysis to the crawl config's spreadsheet, as WriteWild does
+type SheetsExporter struct {
+	CrawlConfig CrawlConfig
+	Logger      Logger // optional
+}
+
+func (e SheetsExporter) Export(ctx context.Context, result *CrawlResult) error {
+	logger := e.Logger
+	if logger == nil {
+		logger = discardLogger{}
+	}
+	return writeWild(logger, result.URLObjectList, result.Analysis, e.CrawlConfig)
+}
+
+// Writes the crawl as JSON (see WriteCrawlJSON)
+type JSONExporter struct {
+	W io.Writer
+}
+
+func (e JSONExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteCrawlJSON(e.W, result.URLObjectList)
+}
+
+// Writes the crawl as CSV (see WriteCrawlCSV)
+type CSVExporter struct {
+	W io.Writer
+}
+
+func (e CSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteCrawlCSV(e.W, result.URLObjectList)
 }
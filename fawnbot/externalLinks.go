@@ -0,0 +1,168 @@
+package fawnbot
+
+/*
+| - - externalLinks.go - -
+| External link checking: each page's links off the site, checked once per run for broken outbound links
+*/
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const externalCheckWorkers = 8 // external hosts whose links are checked at once
+
+// A link from a page to another site
+type ExternalLink struct {
+	URL     string
+	Fetched LinkStatus
+}
+
+func (l ExternalLink) IsBroken() bool {
+	return isBrokenStatus(l.Fetched.Status)
+}
+
+// Shares the cache of external link checks with other crawls, so links common to several sites in a run are
+// only checked once. The cache also spaces out requests to each external host across those crawls.
+func WithLinkCache(cache *LinkCache) Option {
+	return func(c *Crawler) {
+		c.linkCache = cache
+	}
+}
+
+// returns a checker for external links, which HEADs (or GETs) each one, waiting delayMs between requests to the same host
+func newExternalLinkChecker(client *http.Client, cache *LinkCache, delayMs int) *linkChecker {
+	if cache == nil {
+		cache = NewLinkCache()
+	}
+	return &linkChecker{client: client, cache: cache, hostDelay: time.Duration(max(delayMs, 0)) * time.Millisecond, retryGET: true}
+}
+
+// returns a page's external links once each, in the order they appear. They're checked once the crawl is done.
+func findExternalLinks(pageURL, root string, links []string, scope Scope) []ExternalLink {
+	var external []ExternalLink
+	seen := make(map[string]bool)
+	for _, link := range links {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		link = normaliseWWW(resolveReference(pageURL, link), root)
+		if seen[link] || !isWebURL(link) || !isExternalLink(scope, root, link) {
+			continue
+		}
+		seen[link] = true
+		external = append(external, ExternalLink{URL: link})
+	}
+	return external
+}
+
+// checks every external link the crawl found once, then records what was found on each page linking to it.
+// Each host's links are checked in turn, several hosts at once, so slow hosts don't hold up the crawl or each other.
+func checkExternalLinks(ctx context.Context, checker *linkChecker, store crawlStore) error {
+	byHost := make(map[string][]string)
+	seen := make(map[string]bool)
+	err := store.ForEach(func(url string, obj *URLObject) error {
+		for _, link := range obj.ExternalLinks {
+			if !seen[link.URL] {
+				seen[link.URL] = true
+				host := strings.ToLower(extractHost(link.URL))
+				byHost[host] = append(byHost[host], link.URL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hosts := make(chan []string)
+	var wg sync.WaitGroup
+	for i := 0; i < externalCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for links := range hosts {
+				for _, link := range links {
+					checker.check(ctx, link) // remembered by the checker's cache
+				}
+			}
+		}()
+	}
+	for _, host := range sortedKeys(byHost) {
+		hosts <- byHost[host]
+	}
+	close(hosts)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err() // the links are checked again on resume
+	}
+
+	err = store.ForEach(func(url string, obj *URLObject) error {
+		if len(obj.ExternalLinks) == 0 {
+			return nil
+		}
+		for i := range obj.ExternalLinks {
+			obj.ExternalLinks[i].Fetched = checker.check(ctx, obj.ExternalLinks[i].URL)
+		}
+		return store.Put(url, obj)
+	})
+	if err != nil {
+		return err
+	}
+	return store.Flush()
+}
+
+// reports whether a link leaves the site, i.e. it's on a host the crawl's scope doesn't cover
+func isExternalLink(scope Scope, root, link string) bool {
+	if reasoner, ok := scope.(scopeReasoner); ok {
+		return reasoner.outOfScopeReason(link) == outOfScopeHost
+	}
+	return !strings.EqualFold(extractHost(link), extractHost(root)) && !scope.InScope(link)
+}
+
+func brokenExternalLinks(links []ExternalLink) int {
+	count := 0
+	for _, link := range links {
+		if link.IsBroken() {
+			count++
+		}
+	}
+	return count
+}
+
+// Writes every external link in a crawl as CSV, one row per link with the pages linking to it, sorted by URL
+func WriteExternalLinksCSV(w io.Writer, URLObjectList URLObjectList) error {
+	found := make(map[string]ExternalLink)
+	sources := make(map[string][]string)
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		for _, link := range obj.ExternalLinks {
+			found[link.URL] = link
+			sources[link.URL] = append(sources[link.URL], url)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rows := [][]interface{}{{"External URL", "Status", "Broken", "Content Type", "Source Pages", "Sources"}}
+	for _, url := range sortedKeys(found) {
+		link := found[url]
+		rows = append(rows, []interface{}{url, link.Fetched.Status, link.IsBroken(), link.Fetched.ContentType, len(sources[url]), strings.Join(sources[url], " | ")})
+	}
+	return writeCSV(w, rows)
+}
+
+// Writes the crawl's external links as CSV (see WriteExternalLinksCSV)
+type ExternalLinksCSVExporter struct {
+	W io.Writer
+}
+
+func (e ExternalLinksCSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteExternalLinksCSV(e.W, result.URLObjectList)
+}
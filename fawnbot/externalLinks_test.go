@@ -0,0 +1,44 @@
+package fawnbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// each external link is checked once, and its status recorded on every page linking to it
+func TestCheckExternalLinks(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	state := newCrawlState("https://example.com/")
+	scope, err := (*ScopeConfig)(nil).compile("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := []string{server.URL + "/ok", server.URL + "/gone", "/about", server.URL + "/ok"}
+	for _, page := range []string{"https://example.com/", "https://example.com/about"} {
+		state.Put(page, &URLObject{ExternalLinks: findExternalLinks(page, "https://example.com/", links, scope)})
+	}
+
+	checker := newExternalLinkChecker(newNoRedirectClient(server.Client()), nil, 0)
+	if err := checkExternalLinks(context.Background(), checker, state); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 3 { // a HEAD each, with the 404 retried as a GET
+		t.Errorf("%d requests, want 3", requests.Load())
+	}
+	for url, obj := range state.URLObjects {
+		if len(obj.ExternalLinks) != 2 || obj.ExternalLinks[0].Fetched.Status != 200 || !obj.ExternalLinks[1].IsBroken() {
+			t.Errorf("%s: external links %+v", url, obj.ExternalLinks)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package fawnbot
+
+/*
+| - - extract.go - -
+| Custom extraction: named CSS selectors, XPath expressions and regexes, run against every page
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// An Extractor pulls a named value, such as a SKU or publish date, from every page.
+// For example: {"Name": "Price", "Type": "css", "Selector": "span.price", "Mode": "text"}
+type Extractor struct {
+	Name      string `json:"Name"`
+	Type      string `json:"Type"`                // css, xpath or regex
+	Selector  string `json:"Selector"`            // the selector, XPath expression or regular expression
+	Mode      string `json:"Mode,omitempty"`      // text (default), attribute, html or count
+	Attribute string `json:"Attribute,omitempty"` // the attribute read in attribute mode
+	All       bool   `json:"All,omitempty"`       // joins every match with " | ", rather than taking the first
+}
+
+const (
+	extractCSS   = "css"
+	extractXPath = "xpath"
+	extractRegex = "regex"
+
+	extractText      = "text"
+	extractAttribute = "attribute"
+	extractHTML      = "html"
+	extractCount     = "count"
+)
+
+// a compiled extractor
+type extraction struct {
+	Extractor
+	css   cascadia.Selector
+	xpath *xpath.Expr
+	regex *regexp.Regexp
+}
+
+// compiles extractors, reporting every invalid one
+func compileExtractors(extractors []Extractor) ([]extraction, error) {
+	var compiled []extraction
+	var problems []string
+	names := make(map[string]bool)
+
+	for i, e := range extractors {
+		if e.Mode == "" {
+			e.Mode = extractText
+		}
+		e.Type = strings.ToLower(e.Type)
+		e.Mode = strings.ToLower(e.Mode)
+		x := extraction{Extractor: e}
+
+		if e.Name == "" {
+			problems = append(problems, fmt.Sprintf("extractor %d: missing Name", i+1))
+			continue
+		}
+		if names[e.Name] {
+			problems = append(problems, fmt.Sprintf("extractor '%s': duplicate Name", e.Name))
+			continue
+		}
+		names[e.Name] = true
+
+		var err error
+		switch e.Type {
+		case extractCSS:
+			x.css, err = cascadia.Compile(e.Selector)
+		case extractXPath:
+			x.xpath, err = xpath.Compile(e.Selector)
+		case extractRegex:
+			x.regex, err = regexp.Compile(e.Selector)
+		default:
+			err = fmt.Errorf("unknown Type '%s': expected css, xpath or regex", e.Type)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("extractor '%s': %v", e.Name, err))
+			continue
+		}
+
+		switch {
+		case e.Mode != extractText && e.Mode != extractAttribute && e.Mode != extractHTML && e.Mode != extractCount:
+			problems = append(problems, fmt.Sprintf("extractor '%s': unknown Mode '%s': expected text, attribute, html or count", e.Name, e.Mode))
+		case e.Mode == extractAttribute && e.Attribute == "":
+			problems = append(problems, fmt.Sprintf("extractor '%s': attribute mode needs an Attribute", e.Name))
+		case e.Type == extractRegex && (e.Mode == extractAttribute || e.Mode == extractHTML):
+			problems = append(problems, fmt.Sprintf("extractor '%s': regex extractors only support text and count modes", e.Name))
+		default:
+			compiled = append(compiled, x)
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return compiled, nil
+}
+
+// runs the extractor against a page. Regexes match the raw HTML, selectors the parsed DOM.
+func (x extraction) extract(body []byte, doc *html.Node) string {
+	var matches []string
+
+	if x.Type == extractRegex {
+		for _, match := range x.regex.FindAllSubmatch(body, -1) {
+			if len(match) > 1 {
+				matches = append(matches, string(match[1])) // the first capture group, if there is one
+			} else {
+				matches = append(matches, string(match[0]))
+			}
+		}
+	} else {
+		if doc == nil {
+			return ""
+		}
+
+		var nodes []*html.Node
+		if x.Type == extractCSS {
+			nodes = cascadia.QueryAll(doc, x.css)
+		} else {
+			nodes = htmlquery.QuerySelectorAll(doc, x.xpath)
+		}
+
+		for _, node := range nodes {
+			switch x.Mode {
+			case extractAttribute:
+				for _, attr := range node.Attr {
+					if attr.Key == x.Attribute {
+						matches = append(matches, attr.Val)
+					}
+				}
+			case extractHTML:
+				var b bytes.Buffer
+				html.Render(&b, node)
+				matches = append(matches, b.String())
+			default:
+				matches = append(matches, strings.Join(strings.Fields(htmlquery.InnerText(node)), " "))
+			}
+		}
+	}
+
+	switch {
+	case x.Mode == extractCount:
+		return strconv.Itoa(len(matches))
+	case len(matches) == 0:
+		return ""
+	case x.All:
+		return strings.Join(matches, " | ")
+	default:
+		return matches[0]
+	}
+}
+
+// - - -
+
+// extractorPlugin runs a crawl config's extractors, storing results in URLObject.Extracted
+type extractorPlugin struct {
+	extractors  []Extractor
+	extractions []extraction
+}
+
+func (p *extractorPlugin) Name() string { return "Extractors" }
+
+// regexes match the body, CSS and XPath the DOM
+func (p *extractorPlugin) readsBody() bool { return p.uses(extractRegex) }
+func (p *extractorPlugin) readsDoc() bool  { return p.uses(extractCSS) || p.uses(extractXPath) }
+
+func (p *extractorPlugin) uses(extractType string) bool {
+	for _, x := range p.extractions {
+		if x.Type == extractType {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *extractorPlugin) OnCrawlStart(ctx context.Context, root string) error {
+	extractions, err := compileExtractors(p.extractors)
+	if err != nil {
+		return err
+	}
+	p.extractions = extractions
+	return nil
+}
+
+func (p *extractorPlugin) OnPage(page *Page) error {
+	if page.Response == nil {
+		return nil
+	}
+	for _, x := range p.extractions {
+		if page.Object.Extracted == nil {
+			page.Object.Extracted = make(map[string]string)
+		}
+		page.Object.Extracted[x.Name] = x.extract(page.Body, page.Doc)
+	}
+	return nil
+}
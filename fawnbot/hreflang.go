@@ -0,0 +1,301 @@
+package fawnbot
+
+/*
+| - - hreflang.go - -
+| Collects hreflang annotations from link tags, Link headers and sitemaps, and validates them once the crawl finishes
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/language"
+)
+
+const (
+	hreflangFromHTML    = "html"
+	hreflangFromHeader  = "header"
+	hreflangFromSitemap = "sitemap"
+)
+
+// One alternate version of a page
+type HreflangLink struct {
+	Lang     string   // e.g. en-GB or x-default
+	URL      string   // absolute
+	Source   string   // html, header or sitemap
+	Problems []string `json:",omitempty"` // found by the post-crawl validation
+}
+
+// returns a page's <link rel="alternate" hreflang> annotations
+func parseHreflangTags(pageURL string, body []byte) []HreflangLink {
+	v := &hreflangVisitor{pageURL: pageURL}
+	scanPage(bytes.NewReader(body), v)
+	return v.links
+}
+
+type hreflangVisitor struct {
+	pageURL string
+	links   []HreflangLink
+}
+
+func (v *hreflangVisitor) visit(t *scanToken) {
+	if (t.Type == html.StartTagToken || t.Type == html.SelfClosingTagToken) && t.Data == "link" && t.has("hreflang") && hasRel(t.attr("rel"), "alternate") {
+		v.links = append(v.links, HreflangLink{Lang: strings.TrimSpace(t.attr("hreflang")), URL: resolveReference(v.pageURL, strings.TrimSpace(t.attr("href"))), Source: hreflangFromHTML})
+	}
+}
+
+var linkHeaderParam = regexp.MustCompile(`;\s*([a-zA-Z]+)\s*=\s*("[^"]*"|[^;,\s]*)`)
+
+// returns the hreflang annotations of Link headers, e.g. Link: <https://example.com/fr/>; rel="alternate"; hreflang="fr"
+func parseHreflangHeaders(pageURL string, header http.Header) []HreflangLink {
+	var links []HreflangLink
+	for _, value := range header.Values("Link") {
+		for _, part := range splitLinkHeader(value) {
+			end := strings.Index(part, ">")
+			if !strings.HasPrefix(part, "<") || end < 0 {
+				continue
+			}
+
+			params := make(map[string]string)
+			for _, match := range linkHeaderParam.FindAllStringSubmatch(part[end+1:], -1) {
+				params[strings.ToLower(match[1])] = strings.Trim(match[2], `"`)
+			}
+			if params["hreflang"] != "" && hasRel(params["rel"], "alternate") {
+				links = append(links, HreflangLink{Lang: params["hreflang"], URL: resolveReference(pageURL, part[1:end]), Source: hreflangFromHeader})
+			}
+		}
+	}
+	return links
+}
+
+// splits a Link header into its links, ignoring commas inside <> and quotes
+func splitLinkHeader(value string) []string {
+	var parts []string
+	inURL, inQuotes, start := false, false, 0
+	for i, r := range value {
+		switch {
+		case r == '<' && !inQuotes:
+			inURL = true
+		case r == '>' && !inQuotes:
+			inURL = false
+		case r == '"' && !inURL:
+			inQuotes = !inQuotes
+		case r == ',' && !inURL && !inQuotes:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+func hasRel(rel, want string) bool {
+	for _, value := range strings.Fields(strings.ToLower(rel)) {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checks an hreflang value is x-default, or an ISO 639-1 language with an optional script and ISO 3166-1 alpha-2 region
+func validHreflang(lang string) bool {
+	if strings.EqualFold(lang, "x-default") {
+		return true
+	}
+
+	parts := strings.Split(lang, "-")
+	if len(parts) > 3 || len(parts[0]) != 2 {
+		return false
+	}
+	if _, err := language.ParseBase(parts[0]); err != nil {
+		return false
+	}
+	for i, part := range parts[1:] {
+		switch {
+		case len(part) == 4 && i == 0 && len(parts) <= 3:
+			if _, err := language.ParseScript(part); err != nil {
+				return false
+			}
+		case len(part) == 2 && i == len(parts)-2:
+			region, err := language.ParseRegion(part)
+			if err != nil || !region.IsCountry() || region.Canonicalize() != region {
+				return false // e.g. UK rather than GB
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// - - -
+
+// validates a page's hreflang annotations against the rest of the crawl
+func (u URLObjectList) validateHreflang(url string, obj *URLObject) error {
+	obj.HreflangProblems = nil
+	if len(obj.Hreflang) == 0 {
+		return nil
+	}
+
+	hasSelf, hasDefault := false, false
+	langs := make(map[string]string) // lang -> URL, to catch one language pointing at different pages
+
+	for i := range obj.Hreflang {
+		link := &obj.Hreflang[i]
+		link.Problems = nil
+		lang := strings.ToLower(link.Lang)
+
+		if !validHreflang(link.Lang) {
+			link.Problems = append(link.Problems, fmt.Sprintf("invalid language code '%s'", link.Lang))
+		}
+		if lang == "x-default" {
+			hasDefault = true
+		}
+		if other, ok := langs[lang]; ok && other != link.URL {
+			link.Problems = append(link.Problems, fmt.Sprintf("'%s' also points to %s", link.Lang, other))
+		} else {
+			langs[lang] = link.URL
+		}
+
+		if link.URL == url {
+			hasSelf = true
+			continue
+		}
+
+		alternate, ok, err := u.Get(link.URL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // not crawled, e.g. on another domain
+		}
+		if alternate.PageStatus != 200 {
+			link.Problems = append(link.Problems, fmt.Sprintf("alternate returns %d", alternate.PageStatus))
+			continue
+		}
+		if !alternate.Indexability {
+			link.Problems = append(link.Problems, "alternate is non-indexable")
+		}
+		if alternate.Canonical != "" && alternate.Canonical != link.URL {
+			link.Problems = append(link.Problems, fmt.Sprintf("alternate is canonicalised to %s", alternate.Canonical))
+		}
+		if !linksTo(alternate.Hreflang, url) {
+			link.Problems = append(link.Problems, "no return link")
+		}
+	}
+
+	if !hasSelf {
+		obj.HreflangProblems = append(obj.HreflangProblems, "no self-referencing hreflang")
+	}
+	if !hasDefault {
+		obj.HreflangProblems = append(obj.HreflangProblems, "no x-default")
+	}
+	for _, link := range obj.Hreflang {
+		for _, problem := range link.Problems {
+			obj.HreflangProblems = append(obj.HreflangProblems, fmt.Sprintf("%s (%s): %s", link.Lang, link.URL, problem))
+		}
+	}
+	return nil
+}
+
+func linksTo(links []HreflangLink, url string) bool {
+	for _, link := range links {
+		if link.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes every hreflang annotation in a crawl as CSV, one row per page and alternate
+func WriteHreflangCSV(w io.Writer, URLObjectList URLObjectList) error {
+	rows := newCSVRows(w)
+	if err := rows.write("URL", "Hreflang", "Alternate URL", "Source", "Alternate Status", "Problems"); err != nil {
+		return err
+	}
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		for _, link := range obj.Hreflang {
+			status := ""
+			alternate, ok, err := URLObjectList.Get(link.URL)
+			if err != nil {
+				return err
+			}
+			if ok {
+				status = fmt.Sprint(alternate.PageStatus)
+			}
+			if err := rows.write(url, link.Lang, link.URL, link.Source, status, joinIssues(link.Problems)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rows.flush()
+}
+
+// Writes the crawl's hreflang annotations as CSV (see WriteHreflangCSV)
+type HreflangCSVExporter struct {
+	W io.Writer
+}
+
+func (e HreflangCSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteHreflangCSV(e.W, result.URLObjectList)
+}
+
+// - - -
+
+// hreflangPlugin records every page's hreflang annotations, including those listed for it in the site's sitemaps
+type hreflangPlugin struct {
+	fromSitemaps bool
+	sitemap      map[string][]HreflangLink // page URL -> alternates
+	client       *http.Client              // fetches the sitemaps
+	logger       Logger
+}
+
+func (p *hreflangPlugin) Name() string { return "Hreflang" }
+
+func (p *hreflangPlugin) newVisitor(pageURL string) tokenVisitor {
+	return &hreflangVisitor{pageURL: pageURL}
+}
+
+func (p *hreflangPlugin) OnCrawlStart(ctx context.Context, root string) error {
+	if !p.fromSitemaps {
+		return nil
+	}
+
+	p.sitemap = make(map[string][]HreflangLink)
+	entries, err := FetchSitemaps(ctx, p.client, p.logger, root)
+	if err != nil {
+		p.logger.Printf("[!] Couldn't read sitemap hreflang: %v", err) // not worth abandoning the crawl over
+		return nil
+	}
+	for _, entry := range entries {
+		for _, alternate := range entry.Alternates {
+			alternate.Source = hreflangFromSitemap
+			p.sitemap[entry.Loc] = append(p.sitemap[entry.Loc], alternate)
+		}
+	}
+	return nil
+}
+
+func (p *hreflangPlugin) OnPage(page *Page) error {
+	var links []HreflangLink
+	if page.isHTML {
+		links = append(links, scanned[*hreflangVisitor](page, p).links...)
+	}
+	if page.Header != nil {
+		links = append(links, parseHreflangHeaders(page.URL, page.Header)...)
+	}
+	links = append(links, p.sitemap[page.URL]...)
+
+	page.Object.Hreflang = links
+	return nil
+}
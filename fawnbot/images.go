@@ -0,0 +1,242 @@
+package fawnbot
+
+/*
+| - - images.go - -
+| Image inventory: <img>, <picture>/srcset and CSS background images, with an alt-text audit
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	imageFromImg     = "img"
+	imageFromSrcset  = "srcset"
+	imageFromPicture = "picture"
+	imageFromCSS     = "css"
+
+	altMissing = "missing"
+	altEmpty   = "empty" // fine for decorative images, but worth reviewing
+	altPresent = "present"
+)
+
+// An image used on a page
+type PageImage struct {
+	URL       string
+	Source    string      // img, srcset (of an img), picture (a <picture>'s <source>) or css
+	Alt       string      `json:",omitempty"`
+	AltStatus string      `json:",omitempty"` // missing, empty or present. Empty for CSS backgrounds, which can't have alt text
+	Width     string      `json:",omitempty"` // as declared in the HTML
+	Height    string      `json:",omitempty"`
+	Fetched   *LinkStatus `json:",omitempty"` // set if images are checked (see ProgramConfig.CheckImages)
+}
+
+var cssBackgroundImage = regexp.MustCompile(`(?i)background(?:-image)?\s*:[^;}]*?url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// returns every image on a page, each URL listed once per way it's used
+func parseImages(pageURL string, body []byte) []PageImage {
+	v := newImagesVisitor(pageURL)
+	scanPage(bytes.NewReader(body), v)
+	return v.images
+}
+
+type imagesVisitor struct {
+	pageURL  string
+	images   []PageImage
+	seen     map[string]bool              // by URL and source
+	pictures map[*scanElement]*pictureAlt // of each <picture> open
+}
+
+// a <picture>'s alt text, which its sources share with its <img>, and the sources waiting for the <img> if it's later
+type pictureAlt struct {
+	found       bool
+	alt, status string
+	sources     []int // in images
+}
+
+func newImagesVisitor(pageURL string) *imagesVisitor {
+	return &imagesVisitor{pageURL: pageURL, seen: make(map[string]bool), pictures: make(map[*scanElement]*pictureAlt)}
+}
+
+// adds an image, returning whether it's new
+func (v *imagesVisitor) add(image PageImage) bool {
+	image.URL = strings.TrimSpace(image.URL)
+	if image.URL == "" || strings.HasPrefix(strings.ToLower(image.URL), "data:") {
+		return false
+	}
+	image.URL = resolveReference(v.pageURL, image.URL)
+	if key := image.URL + "|" + image.Source; !v.seen[key] {
+		v.seen[key] = true
+		v.images = append(v.images, image)
+		return true
+	}
+	return false
+}
+
+func (v *imagesVisitor) visit(t *scanToken) {
+	switch t.Type {
+	case html.TextToken:
+		if parent := t.parent(); parent != nil && parent.Data == "style" {
+			for _, match := range cssBackgroundImage.FindAllStringSubmatch(t.Data, -1) {
+				v.add(PageImage{URL: match[1], Source: imageFromCSS})
+			}
+		}
+		return
+	case html.EndTagToken:
+		delete(v.pictures, t.scanElement)
+		return
+	}
+
+	switch t.Data {
+	case "picture":
+		v.pictures[t.scanElement] = &pictureAlt{}
+	case "img":
+		image := PageImage{Source: imageFromImg, Width: t.attr("width"), Height: t.attr("height")}
+		image.Alt, image.AltStatus = altText(t.scanElement)
+
+		image.URL = t.attr("src")
+		v.add(image)
+		for _, candidate := range parseSrcset(t.attr("srcset")) {
+			image.URL, image.Source = candidate, imageFromSrcset
+			v.add(image)
+		}
+
+		// the first <img> in a <picture> gives its sources their alt text
+		if picture := v.pictures[t.parent()]; picture != nil && !picture.found {
+			picture.found = true
+			picture.alt, picture.status = image.Alt, image.AltStatus
+			for _, i := range picture.sources {
+				v.images[i].Alt, v.images[i].AltStatus = picture.alt, picture.status
+			}
+		}
+	case "source":
+		if picture := v.pictures[t.parent()]; picture != nil {
+			image := PageImage{Source: imageFromPicture, Alt: picture.alt, AltStatus: picture.status}
+			for _, candidate := range parseSrcset(t.attr("srcset")) {
+				image.URL = candidate
+				if v.add(image) && !picture.found {
+					picture.sources = append(picture.sources, len(v.images)-1)
+				}
+			}
+		}
+	}
+	for _, match := range cssBackgroundImage.FindAllStringSubmatch(t.attr("style"), -1) {
+		v.add(PageImage{URL: match[1], Source: imageFromCSS})
+	}
+}
+
+func altText(img *scanElement) (string, string) {
+	if !img.has("alt") {
+		return "", altMissing
+	}
+	alt := strings.TrimSpace(img.attr("alt"))
+	if alt == "" {
+		return "", altEmpty
+	}
+	return alt, altPresent
+}
+
+// returns the URLs of a srcset, e.g. "small.jpg 480w, large.jpg 1080w"
+func parseSrcset(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
+
+// counts <img> tags without alt attributes, ignoring their srcset candidates
+func imagesMissingAlt(images []PageImage) int {
+	count := 0
+	for _, image := range images {
+		if image.Source == imageFromImg && image.AltStatus == altMissing {
+			count++
+		}
+	}
+	return count
+}
+
+func isOversized(image PageImage, maxKB int) bool {
+	return maxKB > 0 && image.Fetched != nil && image.Fetched.Size > int64(maxKB)*1024
+}
+
+// Writes every image in a crawl as CSV, one row per page and image
+func WriteImagesCSV(w io.Writer, URLObjectList URLObjectList) error {
+	rows := newCSVRows(w)
+	if err := rows.write("URL", "Image URL", "Source", "Alt", "Alt Status", "Width", "Height", "Status", "Content Type", "Size (Bytes)", "Oversized"); err != nil {
+		return err
+	}
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		for _, image := range obj.Images {
+			status, contentType, size, oversized := "", "", "", ""
+			if image.Fetched != nil {
+				status, contentType = fmt.Sprint(image.Fetched.Status), image.Fetched.ContentType
+				if image.Fetched.Size >= 0 {
+					size = fmt.Sprint(image.Fetched.Size)
+				}
+				oversized = fmt.Sprint(isOversized(image, URLObjectList.Config.MaxImageKB))
+			}
+			if err := rows.write(url, image.URL, image.Source, image.Alt, image.AltStatus, image.Width, image.Height, status, contentType, size, oversized); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rows.flush()
+}
+
+// Writes the crawl's images as CSV (see WriteImagesCSV)
+type ImagesCSVExporter struct {
+	W io.Writer
+}
+
+func (e ImagesCSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteImagesCSV(e.W, result.URLObjectList)
+}
+
+// - - -
+
+// imagesPlugin inventories every 200 page's images, optionally checking each image once with the crawl's link checker
+type imagesPlugin struct {
+	checker *linkChecker
+	fetch   bool
+	maxKB   int // images without a Content-Length are downloaded to find whether they're larger. 0 for no limit
+}
+
+func (imagesPlugin) Name() string { return "Images" }
+
+func (imagesPlugin) newVisitor(pageURL string) tokenVisitor { return newImagesVisitor(pageURL) }
+
+func (p imagesPlugin) OnPage(page *Page) error {
+	if !page.isHTML {
+		return nil
+	}
+
+	images := scanned[*imagesVisitor](page, p).images
+	if p.fetch {
+		ctx := page.Response.Request.Context()
+		for i := range images {
+			result := p.checker.check(ctx, images[i].URL)
+			if result.Status == 200 && result.Size < 0 && p.maxKB > 0 {
+				// counted up to just past the limit, which is enough to tell if it's oversized
+				result, _ = p.checker.get(ctx, images[i].URL, int64(p.maxKB)*1024+1)
+			}
+			images[i].Fetched = &result
+		}
+	}
+	page.Object.Images = images
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package fawnbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// images served without a Content-Length are downloaded, up to just past MaxImageKB, to find their size
+func TestImagesWithoutContentLength(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := map[string]int{"/small.jpg": 10 * 1024, "/large.jpg": 300 * 1024}[r.URL.Path]
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.(http.Flusher).Flush() // sent chunked, so without a Content-Length
+		if r.Method == "GET" {
+			w.Write(make([]byte, size))
+		}
+	}))
+	defer server.Close()
+
+	body := []byte(`<img src="/small.jpg" alt="a"><img src="/large.jpg" alt="b">`)
+	request := httptest.NewRequest("GET", server.URL+"/", nil)
+	page := &Page{URL: server.URL + "/", Response: &http.Response{Request: request}, Body: body, isHTML: true, Object: &URLObject{}}
+	plugin := imagesPlugin{checker: newLinkChecker(newNoRedirectClient(server.Client())), fetch: true, maxKB: 200}
+	if err := plugin.OnPage(page); err != nil {
+		t.Fatal(err)
+	}
+
+	images := page.Object.Images
+	if len(images) != 2 {
+		t.Fatalf("%d images, want 2", len(images))
+	}
+	if size := images[0].Fetched.Size; size != 10*1024 || isOversized(images[0], 200) {
+		t.Errorf("small image: size %d, oversized %v", size, isOversized(images[0], 200))
+	}
+	if size := images[1].Fetched.Size; size != 200*1024+1 || !isOversized(images[1], 200) {
+		t.Errorf("large image: size %d, oversized %v", size, isOversized(images[1], 200))
+	}
+}
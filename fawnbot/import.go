@@ -9,28 +9,57 @@ package fawnbot
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
-	"time"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 type ProgramConfig struct {
 	RespectRobots      bool   `json:"RespectRobots"`
-	MaxCrawlDepth      int    `json:"MaxCrawlDepth"`      // unimplemented
-	MaxCrawlsPerSecond int    `json:"MaxCrawlsPerSecond"` // unimplemented
+	MaxCrawlDepth      int    `json:"MaxCrawlDepth"`      // 0 or less for no limit
+	MaxCrawlsPerSecond int    `json:"MaxCrawlsPerSecond"` // to each host. 0 or less for no limit
 	ReadSheetID        string `json:"ReadSheetID"`
 	ReadSheetName      string `json:"ReadSheetName"`
+	CheckpointDir      string `json:"CheckpointDir"`      // where interrupted crawls are saved. Empty to disable
+	CheckpointEvery    int    `json:"CheckpointEvery"`    // seconds between checkpoints of running crawls, in case they're killed. 0 or less for only when interrupted
+	HreflangSitemaps   bool   `json:"HreflangSitemaps"`   // also reads hreflang annotations from the site's XML sitemaps
+	CheckImages        bool   `json:"CheckImages"`        // fetches every image once, recording its status, type and size
+	MaxImageKB         int    `json:"MaxImageKB"`         // checked images larger than this are oversized. 0 or less for no limit
+	CheckResources     bool   `json:"CheckResources"`     // audits every page's stylesheets, scripts, preloads and fonts
+	MaxBodyKB          int    `json:"MaxBodyKB"`          // bodies are cut off after this much. 0 or less for no limit
+	ParsePDFs          bool   `json:"ParsePDFs"`          // reads PDFs for their titles and links
+	DiskStoreDir       string `json:"DiskStoreDir"`       // keeps the frontier, seen URLs and results of crawls in databases here, not memory. Empty to disable
+	BloomFilterURLs    int    `json:"BloomFilterURLs"`    // the URLs a disk store's Bloom filter is sized for. 0 for no filter
+	CheckExternalLinks bool   `json:"CheckExternalLinks"` // checks every link to another site once, recording its status
+	ExternalDelayMs    int    `json:"ExternalDelayMs"`    // milliseconds between checks of links to the same external host
+	Workers            int    `json:"Workers"`            // URLs fetched at once. Requests to the same host still take turns
+	AdaptiveDelay      bool   `json:"AdaptiveDelay"`      // slows down requests to hosts whose latency or rate of 429s and 5xxs rises
+	SkipTraps          bool   `json:"SkipTraps"`          // doesn't crawl URLs that look like crawler traps, rather than only flagging them
+	MaxURLLength       int    `json:"MaxURLLength"`       // longer URLs are flagged as traps. 0 or less for no limit
+	MaxParamCombos     int    `json:"MaxParamCombos"`     // sets of query parameters a path may use before more are flagged as traps. 0 or less for no limit
+	MaxSequenceSteps   int    `json:"MaxSequenceSteps"`   // times pages may link onwards through a numeric parameter, e.g. next month, before the rest are flagged as traps. 0 or less for no limit
+	SecretsFile        string `json:"SecretsFile"`        // JSON of the credentials crawl configs' Auth names (see Secret). Empty for the environment only
+}
+
+func DefaultProgramConfig() ProgramConfig {
+	return ProgramConfig{RespectRobots: false, MaxCrawlDepth: 99, MaxCrawlsPerSecond: 10, CheckpointDir: "checkpoints", CheckpointEvery: 60, MaxImageKB: 200, MaxBodyKB: 10240, BloomFilterURLs: 1000000, ExternalDelayMs: 1000, Workers: 1, AdaptiveDelay: true, MaxURLLength: 1024, MaxParamCombos: 64, MaxSequenceSteps: 100}
 }
 
+// Loads the program config. Settings missing from the file keep their defaults.
 func LoadProgramConfig(filename string) (ProgramConfig, error) {
-	defaultConfig := ProgramConfig{RespectRobots: false, MaxCrawlDepth: 99, MaxCrawlsPerSecond: 10}
+	defaultConfig := DefaultProgramConfig()
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return defaultConfig, fmt.Errorf("failed to load config file: %v", err)
 	}
 
-	var config ProgramConfig
+	config := defaultConfig
 	if err := json.Unmarshal(data, &config); err != nil {
 		return defaultConfig, fmt.Errorf("failed to parse JSON: %v", err)
 	}
@@ -41,16 +70,116 @@ func LoadProgramConfig(filename string) (ProgramConfig, error) {
 // - - -
 
 type CrawlConfig struct {
-	Root              string `json:"Root"`
-	CrawlStart        string `json:"CrawlStart"`
-	CrawlFrequency    string `json:"CrawlFrequency"`
-	SheetName         string `json:"SheetName"`
-	AnalysisSheetName string `json:"AnalysisSheetName"`
-	SheetID           string `json:"SheetID"`
-	KeepOldCrawls     bool   `json:"KeepOldCrawls"` // Writes over LatestCrawl and makes a dated copy
+	Root              string         `json:"Root" sheet:"URL,Site,Root URL"`
+	CrawlStart        string         `json:"CrawlStart" sheet:"Start,Start Date"`       // YYYY-MM-DD, optional for cron frequencies
+	CrawlFrequency    string         `json:"CrawlFrequency" sheet:"Frequency,Schedule"` // daily, weekly, fortnightly, monthly or a cron expression
+	Timezone          string         `json:"Timezone" sheet:"Time Zone,TZ"`             // IANA name, e.g. Europe/London. Defaults to UTC
+	SheetName         string         `json:"SheetName" sheet:"Crawl Sheet,Crawl Sheet Name"`
+	AnalysisSheetName string         `json:"AnalysisSheetName" sheet:"Analysis Sheet"`
+	SheetID           string         `json:"SheetID" sheet:"Sheet URL,Sheet Link,Spreadsheet,Spreadsheet URL"`
+	KeepOldCrawls     bool           `json:"KeepOldCrawls"`                                       // Writes over LatestCrawl and makes a dated copy
+	Extractors        []Extractor    `json:"Extractors,omitempty" sheet:"Extract,Extraction"`     // custom data to pull from every page (JSON in the control sheet)
+	SearchRules       []SearchRule   `json:"SearchRules,omitempty" sheet:"Search,Search Rules"`   // patterns to find on, or find missing from, every page (JSON in the control sheet)
+	Scope             *ScopeConfig   `json:"Scope,omitempty" sheet:"Crawl Scope"`                 // which hosts, paths and parameters are crawled (JSON in the control sheet)
+	Requests          *RequestConfig `json:"Requests,omitempty" sheet:"Request Settings,Headers"` // headers, cookies and auth for the site's requests (JSON in the control sheet)
+	CrawlOverrides
+}
+
+// Identifies a site's crawl in the run history
+func (c CrawlConfig) RunKey() string {
+	return fmt.Sprintf("%s|%s|%s", c.Root, c.SheetID, c.SheetName)
+}
+
+// Optional per-site crawl settings. Each field shares its name with a ProgramConfig field and, when set, takes precedence over it.
+// Precedence (lowest to highest): DefaultProgramConfig, programConfig.json, CrawlOverrides.
+type CrawlOverrides struct {
+	RespectRobots      *bool   `json:"RespectRobots,omitempty"`
+	MaxCrawlDepth      *int    `json:"MaxCrawlDepth,omitempty"`
+	MaxCrawlsPerSecond *int    `json:"MaxCrawlsPerSecond,omitempty"`
+	CheckpointEvery    *int    `json:"CheckpointEvery,omitempty"`
+	HreflangSitemaps   *bool   `json:"HreflangSitemaps,omitempty"`
+	CheckImages        *bool   `json:"CheckImages,omitempty"`
+	MaxImageKB         *int    `json:"MaxImageKB,omitempty"`
+	CheckResources     *bool   `json:"CheckResources,omitempty"`
+	MaxBodyKB          *int    `json:"MaxBodyKB,omitempty"`
+	ParsePDFs          *bool   `json:"ParsePDFs,omitempty"`
+	DiskStoreDir       *string `json:"DiskStoreDir,omitempty"`
+	BloomFilterURLs    *int    `json:"BloomFilterURLs,omitempty"`
+	CheckExternalLinks *bool   `json:"CheckExternalLinks,omitempty"`
+	ExternalDelayMs    *int    `json:"ExternalDelayMs,omitempty"`
+	Workers            *int    `json:"Workers,omitempty"`
+	AdaptiveDelay      *bool   `json:"AdaptiveDelay,omitempty"`
+	SkipTraps          *bool   `json:"SkipTraps,omitempty"`
+	MaxURLLength       *int    `json:"MaxURLLength,omitempty"`
+	MaxParamCombos     *int    `json:"MaxParamCombos,omitempty"`
+	MaxSequenceSteps   *int    `json:"MaxSequenceSteps,omitempty"`
+}
+
+// ProgramConfig fields that apply to the whole program, so can't be overridden per site.
+// A shared control sheet mustn't choose where credentials are read from or checkpoints are written.
+var programOnlyFields = []string{"ReadSheetID", "ReadSheetName", "CheckpointDir", "SecretsFile"}
+
+// Returns the program config with this site's overrides layered on top
+func (c CrawlConfig) EffectiveConfig(config ProgramConfig) ProgramConfig {
+	overrides := reflect.ValueOf(c.CrawlOverrides)
+	effective := reflect.ValueOf(&config).Elem()
+
+	for i := 0; i < overrides.NumField(); i++ {
+		override := overrides.Field(i)
+		if override.IsNil() {
+			continue
+		}
+		if field := effective.FieldByName(overrides.Type().Field(i).Name); field.IsValid() {
+			field.Set(override.Elem())
+		}
+	}
+
+	return config
+}
+
+// Checks a crawl config has everything needed to crawl and export, returning every problem found
+func ValidateCrawlConfig(c CrawlConfig) error {
+	var problems []string
+
+	if c.Root == "" {
+		problems = append(problems, "missing Root")
+	} else if parsed, err := url.Parse(c.Root); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, fmt.Sprintf("invalid Root '%s': expected an absolute http(s) URL", c.Root))
+	}
+
+	if _, _, err := parseSchedule(c); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if _, err := compileExtractors(c.Extractors); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := compileSearchRules(c.SearchRules); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := c.Scope.compile(c.Root); err != nil {
+		problems = append(problems, err.Error())
+	}
+	problems = append(problems, c.Requests.validate()...)
+
+	if c.SheetID == "" {
+		problems = append(problems, "missing SheetID")
+	}
+	if c.SheetName == "" {
+		problems = append(problems, "missing SheetName")
+	}
+	if c.AnalysisSheetName == "" {
+		problems = append(problems, "missing AnalysisSheetName")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
 }
 
-func loadCrawlConfig(filepath string) (CrawlConfig, error) {
+// Loads a crawl config from a JSON file
+func LoadCrawlConfig(filepath string) (CrawlConfig, error) {
 	data, err := os.ReadFile(filepath)
 	if err != nil {
 		return CrawlConfig{}, fmt.Errorf("failed to load file: %v", err)
@@ -73,7 +202,7 @@ func LoadCrawlConfigs() ([]CrawlConfig, error) {
 	}
 
 	for _, filepath := range matches {
-		cfg, err := loadCrawlConfig(filepath)
+		cfg, err := LoadCrawlConfig(filepath)
 		if err != nil {
 			fmt.Printf("[!] Error loading %s: %v\n", filepath, err)
 			continue
@@ -85,69 +214,117 @@ func LoadCrawlConfigs() ([]CrawlConfig, error) {
 	return crawlConfigs, nil
 }
 
-// Note: this read is highly-dependent on the configuration of the read sheet. If values or column order changes, the program will not return any configs.
+// Reads crawl configs from the control sheet. Columns are matched to CrawlConfig fields by their header (see configMapper.go),
+// so they may appear in any order and unknown or optional columns are tolerated. Each row's outcome is written back to the Status column.
 func FetchCrawlConfigsFromSheet(sheetID, sheetName string) ([]CrawlConfig, error) {
 	service, err := startNewSheetsService()
 	if err != nil {
 		return []CrawlConfig{}, fmt.Errorf("failed to start new Sheets service: %v", err)
 	}
 
-	readRange := sheetName + "!A2:G" // skip header, cols A-F
-	response, err := service.Spreadsheets.Values.Get(sheetID, readRange).Do()
+	rows, statusColumn, headerCount, err := readControlSheet(service, sheetID, sheetName, log.New(os.Stdout, "", 0))
 	if err != nil {
 		return nil, err
 	}
 
 	var crawlConfigs []CrawlConfig
-	for _, row := range response.Values {
-		if len(row) < 7 {
-			continue //ignore incomplete rows
+	var statuses []interface{}
+	for _, row := range rows {
+		switch {
+		case row.Blank:
+			statuses = append(statuses, "")
+		case row.Err != nil:
+			fmt.Printf("[!] Skipping control sheet row %d: %v\n", row.Row, row.Err)
+			statuses = append(statuses, "Error: "+row.Err.Error())
+		default:
+			statuses = append(statuses, "OK")
+			crawlConfigs = append(crawlConfigs, row.Config)
 		}
+	}
 
-		keepOldCrawls := false
-		if val, ok := row[6].(string); ok && strings.ToLower(val) == "true" {
-			keepOldCrawls = true
-		}
+	if err := writeConfigStatuses(service, sheetID, sheetName, statusColumn, headerCount, statuses); err != nil {
+		fmt.Println("[!] Error writing config statuses to control sheet:", err)
+	}
 
-		config := CrawlConfig{
-			Root:              row[0].(string),
-			CrawlStart:        row[1].(string),
-			CrawlFrequency:    row[2].(string),
-			SheetName:         row[3].(string),
-			AnalysisSheetName: row[4].(string),
-			SheetID:           extractSheetIDFromURL(row[5].(string)),
-			KeepOldCrawls:     keepOldCrawls,
-		}
+	return crawlConfigs, nil
+}
+
+type ControlSheetRow struct {
+	Row    int // 1-based sheet row number
+	Config CrawlConfig
+	Err    error // why the row is invalid, if it is
+	Blank  bool
+}
 
-		crawlConfigs = append(crawlConfigs, config)
+// Reads and validates every row of the control sheet, without writing anything back. Unknown columns are reported to logger.
+func ReadControlSheet(sheetID, sheetName string, logger Logger) ([]ControlSheetRow, error) {
+	service, err := startNewSheetsService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start new Sheets service: %v", err)
 	}
 
-	return crawlConfigs, nil
+	rows, _, _, err := readControlSheet(service, sheetID, sheetName, logger)
+	return rows, err
 }
 
-func IsSiteDue(s CrawlConfig) (bool, error) {
-	startDate, err := time.Parse("2006-01-02", s.CrawlStart)
+// returns the parsed rows, the status column's index (-1 if absent) and the number of header cells
+func readControlSheet(service *sheets.Service, sheetID, sheetName string, logger Logger) ([]ControlSheetRow, int, int, error) {
+	response, err := service.Spreadsheets.Values.Get(sheetID, sheetName).Do()
 	if err != nil {
-		return false, fmt.Errorf("invalid date format: %v", err)
-	}
-
-	today := time.Now().Truncate(24 * time.Hour)
-	startDate = startDate.Truncate(24 * time.Hour)
-
-	switch strings.ToLower(s.CrawlFrequency) {
-	case "daily":
-		return true, nil
-	case "weekly":
-		daysPassed := int(today.Sub(startDate).Hours() / 24)
-		return daysPassed >= 0 && daysPassed%7 == 0, nil
-	case "fortnightly":
-		daysPassed := int(today.Sub(startDate).Hours() / 24)
-		return daysPassed >= 0 && daysPassed%14 == 0, nil
-	case "monthly":
-		return startDate.Day() == today.Day(), nil
-	default:
-		return false, fmt.Errorf("invalid crawl frequency: '%s'. Expected one of: daily, weekly, fortnightly, monthly", s.CrawlFrequency)
+		return nil, 0, 0, err
+	}
+	if len(response.Values) == 0 {
+		return nil, 0, 0, fmt.Errorf("control sheet '%s' has no header row", sheetName)
+	}
+
+	columns, statusColumn := mapSheetHeaders(response.Values[0], logger)
+
+	var rows []ControlSheetRow
+	for i, cells := range response.Values[1:] {
+		row := ControlSheetRow{Row: i + 2, Blank: isBlankRow(cells)}
+		if !row.Blank {
+			row.Config, row.Err = parseCrawlConfigRow(columns, cells)
+			if row.Err == nil {
+				row.Config.SheetID = extractSheetIDFromURL(row.Config.SheetID)
+				row.Err = ValidateCrawlConfig(row.Config)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, statusColumn, len(response.Values[0]), nil
+}
+
+// writes one status per config row to the Status column, adding the column after the last header if needed
+func writeConfigStatuses(service *sheets.Service, sheetID, sheetName string, statusColumn, headerCount int, statuses []interface{}) error {
+	if statusColumn < 0 {
+		statusColumn = headerCount
+	}
+
+	values := [][]interface{}{{statusHeader}}
+	for _, status := range statuses {
+		values = append(values, []interface{}{status})
+	}
+
+	writeRange := fmt.Sprintf("%s!%s1", sheetName, columnLetter(statusColumn))
+	_, err := service.Spreadsheets.Values.Update(sheetID, writeRange, &sheets.ValueRange{Values: values}).ValueInputOption("RAW").Do()
+	if err != nil {
+		return fmt.Errorf("failed to write statuses: %v", err)
+	}
+
+	return nil
+}
+
+// Reads a crawl previously written by WriteCrawlJSON
+func ReadCrawlJSON(r io.Reader) (URLObjectList, error) {
+	var list URLObjectList
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return URLObjectList{}, fmt.Errorf("failed to parse crawl JSON: %v", err)
+	}
+	if list.URLObjects == nil {
+		list.URLObjects = make(map[string]*URLObject)
 	}
+	return list, nil
 }
 
 func extractSheetIDFromURL(url string) string {
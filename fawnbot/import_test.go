@@ -0,0 +1,45 @@
+package fawnbot
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// every crawl setting can be overridden per site, with the same type
+func TestCrawlOverridesCoverProgramConfig(t *testing.T) {
+	overrides := reflect.TypeOf(CrawlOverrides{})
+	program := reflect.TypeOf(ProgramConfig{})
+	for i := 0; i < program.NumField(); i++ {
+		field := program.Field(i)
+		override, ok := overrides.FieldByName(field.Name)
+		if slices.Contains(programOnlyFields, field.Name) {
+			if ok {
+				t.Errorf("%s is program-only but has an override", field.Name)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("ProgramConfig.%s has no CrawlOverrides field", field.Name)
+		} else if override.Type != reflect.PointerTo(field.Type) {
+			t.Errorf("CrawlOverrides.%s is %v, want %v", field.Name, override.Type, reflect.PointerTo(field.Type))
+		}
+	}
+	for i := 0; i < overrides.NumField(); i++ {
+		if _, ok := program.FieldByName(overrides.Field(i).Name); !ok {
+			t.Errorf("CrawlOverrides.%s overrides nothing", overrides.Field(i).Name)
+		}
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	workers, dir := 4, "stores"
+	site := CrawlConfig{CrawlOverrides: CrawlOverrides{Workers: &workers, DiskStoreDir: &dir}}
+	effective := site.EffectiveConfig(DefaultProgramConfig())
+
+	want := DefaultProgramConfig()
+	want.Workers, want.DiskStoreDir = 4, "stores"
+	if effective != want {
+		t.Errorf("EffectiveConfig = %+v, want %+v", effective, want)
+	}
+}
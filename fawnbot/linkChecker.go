@@ -0,0 +1,186 @@
+package fawnbot
+
+/*
+| - - linkChecker.go - -
+| Lightweight, cached checks for URLs that aren't crawled, such as og:image URLs and page resources
+*/
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What a HEAD request found at a URL, after following redirects
+type LinkStatus struct {
+	Status       int    // 0 if unreachable
+	ContentType  string `json:",omitempty"`
+	Size         int64  // from Content-Length. -1 if unknown
+	CacheControl string `json:",omitempty"`
+	Expires      string `json:",omitempty"`
+}
+
+// Remembers what link checks found, so crawls in the same run can share them (see WithLinkCache). Safe for concurrent use.
+type LinkCache struct {
+	mu          sync.Mutex
+	results     map[string]LinkStatus
+	nextRequest map[string]time.Time // when each host may next be requested, by checkers with a host delay
+}
+
+func NewLinkCache() *LinkCache {
+	return &LinkCache{results: make(map[string]LinkStatus), nextRequest: make(map[string]time.Time)}
+}
+
+// linkChecker HEADs URLs, following redirects, and remembers what it found for the rest of the crawl
+type linkChecker struct {
+	client    *http.Client // must not follow redirects itself
+	cache     *LinkCache
+	hostDelay time.Duration // the minimum time between requests to the same host. 0 for no limit
+	retryGET  bool          // retries HEADs that fail with 4xx or 5xx as GETs, as some servers mishandle HEAD
+}
+
+func newLinkChecker(client *http.Client) *linkChecker {
+	return &linkChecker{client: client, cache: NewLinkCache()}
+}
+
+// returns the URL's final status, or 0 if it's unreachable
+func (l *linkChecker) status(ctx context.Context, target string) int {
+	return l.check(ctx, target).Status
+}
+
+func (l *linkChecker) check(ctx context.Context, target string) LinkStatus {
+	l.cache.mu.Lock()
+	result, ok := l.cache.results[target]
+	l.cache.mu.Unlock()
+	if ok {
+		return result
+	}
+
+	result = l.fetch(ctx, target)
+	if ctx.Err() != nil {
+		return result // don't cache checks cut short by cancellation
+	}
+
+	l.cache.mu.Lock()
+	l.cache.results[target] = result
+	l.cache.mu.Unlock()
+
+	return result
+}
+
+func (l *linkChecker) fetch(ctx context.Context, target string) LinkStatus {
+	for i := 0; i <= 5; i++ {
+		result, location := l.request(ctx, "HEAD", target)
+		// some servers don't support HEAD
+		if result.Status == http.StatusMethodNotAllowed || result.Status == http.StatusNotImplemented || (l.retryGET && result.Status >= 400) {
+			result, location = l.request(ctx, "GET", target)
+		}
+		if result.Status < 300 || result.Status >= 400 || location == "" {
+			return result
+		}
+		target = resolveReference(target, location)
+	}
+	return LinkStatus{Size: -1} // too many redirects
+}
+
+// GETs a URL, following redirects, and returns up to maxBody bytes of its body (all of it if maxBody is negative).
+// What it found is remembered as a check of the URL would be, so the URL isn't requested again to check it.
+func (l *linkChecker) get(ctx context.Context, target string, maxBody int64) (LinkStatus, []byte) {
+	result, body := LinkStatus{Size: -1}, []byte(nil)
+	for i, url := 0, target; i <= 5; i++ {
+		var location string
+		result, location, body = l.requestBody(ctx, "GET", url, maxBody)
+		if result.Status < 300 || result.Status >= 400 || location == "" {
+			break
+		}
+		if i == 5 {
+			result, body = LinkStatus{Size: -1}, nil // too many redirects
+		}
+		url = resolveReference(url, location)
+	}
+	if ctx.Err() != nil {
+		return result, body
+	}
+
+	l.cache.mu.Lock()
+	l.cache.results[target] = result
+	l.cache.mu.Unlock()
+
+	return result, body
+}
+
+func (l *linkChecker) request(ctx context.Context, method, target string) (LinkStatus, string) {
+	result, location, _ := l.requestBody(ctx, method, target, 0)
+	return result, location
+}
+
+// as request, also returning up to maxBody bytes of the body: none if 0, all of it if negative. Without a
+// Content-Length, the size is what was read, so a body cut short is reported as maxBody bytes.
+func (l *linkChecker) requestBody(ctx context.Context, method, target string, maxBody int64) (LinkStatus, string, []byte) {
+	if err := l.waitForHost(ctx, target); err != nil {
+		return LinkStatus{Size: -1}, "", nil
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return LinkStatus{Size: -1}, "", nil
+	}
+	request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; fawnbot)")
+
+	response, err := l.client.Do(request)
+	if err != nil {
+		return LinkStatus{Size: -1}, "", nil
+	}
+	defer response.Body.Close()
+
+	result := LinkStatus{
+		Status:       response.StatusCode,
+		ContentType:  response.Header.Get("Content-Type"),
+		Size:         response.ContentLength,
+		CacheControl: response.Header.Get("Cache-Control"),
+		Expires:      response.Header.Get("Expires"),
+	}
+	var body []byte
+	if maxBody != 0 {
+		reader := io.Reader(response.Body)
+		if maxBody > 0 {
+			reader = io.LimitReader(response.Body, maxBody)
+		}
+		if body, err = io.ReadAll(reader); err != nil {
+			return LinkStatus{Size: -1}, "", nil
+		}
+		if result.Size < 0 {
+			result.Size = int64(len(body)) // e.g. a chunked response
+		}
+	}
+	return result, response.Header.Get("Location"), body
+}
+
+// waits until the target's host may be requested again, reserving the next slot
+func (l *linkChecker) waitForHost(ctx context.Context, target string) error {
+	if l.hostDelay <= 0 {
+		return nil
+	}
+	host := strings.ToLower(extractHost(target))
+
+	l.cache.mu.Lock()
+	at := l.cache.nextRequest[host]
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	l.cache.nextRequest[host] = at.Add(l.hostDelay)
+	l.cache.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
+
+func isBrokenStatus(status int) bool {
+	return status == 0 || status >= 400
+}
@@ -0,0 +1,295 @@
+package fawnbot
+
+/*
+| - - login.go - -
+| Form login: submits a site's login form before crawling, and again whenever the crawl finds itself logged out
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxLoginRedirects = 10 // followed after submitting the form
+	maxLoginFailures  = 3  // failed logins in a row before the crawl stops trying to log back in
+)
+
+// A login form, submitted before crawling with the session's cookies kept in the crawl's cookie jar. As with Auth,
+// credentials aren't set here: the username and password fields are filled from the named secret.
+type LoginConfig struct {
+	URL           string            // the page with the login form
+	Fields        map[string]string `json:",omitempty"` // values to submit, on top of the form's own (e.g. hidden CSRF tokens)
+	UsernameField string            `json:",omitempty"` // the field filled with the secret's Username, e.g. email
+	PasswordField string            `json:",omitempty"` // the field filled with the secret's Password
+	Secret        string            `json:",omitempty"` // names the credentials in the secrets file or environment
+	SuccessText   string            `json:",omitempty"` // the page reached after logging in contains this. By default, any page but the login page will do
+	LoggedOutText string            `json:",omitempty"` // pages containing this mean the session has ended. Redirects to the login page always do
+}
+
+// Checks a login config, returning every problem found
+func (l *LoginConfig) validate() []string {
+	if l == nil {
+		return nil
+	}
+	var problems []string
+	if parsed, err := url.Parse(l.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, fmt.Sprintf("invalid Login URL '%s': expected an absolute http(s) URL", l.URL))
+	}
+	if (l.UsernameField != "" || l.PasswordField != "") && l.Secret == "" {
+		problems = append(problems, "missing Login Secret for its username and password fields")
+	}
+	return problems
+}
+
+// the form fields of a login page, and where they're submitted
+type loginForm struct {
+	action string
+	method string
+	values url.Values
+}
+
+// returns the page's login form: the one with passwordField (or any password input), or else its first form
+func findLoginForm(pageURL string, doc *html.Node, passwordField string) *loginForm {
+	var forms []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "form" {
+			forms = append(forms, n)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if len(forms) == 0 {
+		return nil
+	}
+
+	chosen := forms[0]
+	for _, form := range forms {
+		if formHasPassword(form, passwordField) {
+			chosen = form
+			break
+		}
+	}
+
+	form := &loginForm{action: pageURL, method: strings.ToUpper(getAttr(chosen, "method")), values: make(url.Values)}
+	if action := strings.TrimSpace(getAttr(chosen, "action")); action != "" {
+		form.action = resolveReference(pageURL, action)
+	}
+	if form.method != "GET" {
+		form.method = "POST"
+	}
+
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && getAttr(n, "name") != "" {
+			name := getAttr(n, "name")
+			switch n.Data {
+			case "input":
+				switch strings.ToLower(getAttr(n, "type")) {
+				case "submit", "button", "image", "reset", "file":
+				case "checkbox", "radio":
+					if hasAttr(n, "checked") {
+						form.values.Add(name, getAttr(n, "value"))
+					}
+				default:
+					form.values.Add(name, getAttr(n, "value"))
+				}
+			case "textarea":
+				form.values.Add(name, nodeText(n))
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(chosen)
+	return form
+}
+
+func formHasPassword(n *html.Node, passwordField string) bool {
+	if n.Type == html.ElementNode && n.Data == "input" {
+		if (passwordField != "" && getAttr(n, "name") == passwordField) || strings.EqualFold(getAttr(n, "type"), "password") {
+			return true
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if formHasPassword(child, passwordField) {
+			return true
+		}
+	}
+	return false
+}
+
+// logs in by submitting the login form, following any redirects so the session's cookies reach the jar.
+// Pages without a form have the configured fields posted straight to them.
+func (s *session) login(ctx context.Context, client *http.Client) error {
+	config := s.loginConfig
+	if err := s.checkLoginHost(config.URL); err != nil {
+		return err
+	}
+	page, err := fetchPage(ctx, client, config.URL, fetchOptions{})
+	if page == nil {
+		return fmt.Errorf("login page unreachable: %v", err)
+	}
+
+	form := &loginForm{action: config.URL, method: "POST", values: make(url.Values)}
+	if doc, err := html.Parse(bytes.NewReader(page.Body)); err == nil && page.Response.StatusCode == 200 {
+		if found := findLoginForm(config.URL, doc, config.PasswordField); found != nil {
+			form = found
+		}
+	}
+	for name, value := range config.Fields {
+		form.values.Set(name, value)
+	}
+	if config.UsernameField != "" {
+		form.values.Set(config.UsernameField, s.loginSecret.Username)
+	}
+	if config.PasswordField != "" {
+		form.values.Set(config.PasswordField, s.loginSecret.Password)
+	}
+	if err := s.checkLoginHost(form.action); err != nil {
+		return err // e.g. a form posting to another site
+	}
+
+	status, finalURL, body, err := submitForm(ctx, client, form)
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("login failed: %s returned %d", finalURL, status)
+	}
+	if config.SuccessText != "" {
+		if !bytes.Contains(body, []byte(config.SuccessText)) {
+			return fmt.Errorf("login failed: %s doesn't contain '%s'", finalURL, config.SuccessText)
+		}
+	} else if s.isLoginPage(finalURL) {
+		return fmt.Errorf("login failed: still on the login page")
+	}
+
+	s.loggedIn = time.Now()
+	return nil
+}
+
+// refuses login pages and forms that aren't on the hosts of the login's secret or, without a secret, the site's own
+func (s *session) checkLoginHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid login URL '%s': %v", rawURL, err)
+	}
+	if s.loginConfig.Secret != "" {
+		if !s.loginSecret.allowsHost(u) {
+			return fmt.Errorf("login URL %s isn't on the hosts of secret '%s'", rawURL, s.loginConfig.Secret)
+		}
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.isSite(u) {
+		return fmt.Errorf("login URL %s isn't on the site's hosts", rawURL)
+	}
+	return nil
+}
+
+// submits a form, then follows redirects. Returns the final status, URL and body.
+func submitForm(ctx context.Context, client *http.Client, form *loginForm) (int, string, []byte, error) {
+	target, body := form.action, strings.NewReader(form.values.Encode())
+	if form.method == "GET" {
+		target, body = setQuery(form.action, form.values.Encode()), strings.NewReader("")
+	}
+	request, err := http.NewRequestWithContext(ctx, form.method, target, body)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	request.Header.Set("User-Agent", "Mozilla/5.0 (compatible; fawnbot)")
+	if form.method == "POST" {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to submit login form: %v", err)
+	}
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("failed to read login response: %v", err)
+	}
+
+	status, location := response.StatusCode, redirectLocation(response)
+	for redirects := 0; location != "" && redirects < maxLoginRedirects; redirects++ {
+		target = resolveReference(target, location)
+		page, err := fetchPage(ctx, client, target, fetchOptions{})
+		if page == nil {
+			return 0, "", nil, fmt.Errorf("failed to follow login redirect to %s: %v", target, err)
+		}
+		if status, location = page.Response.StatusCode, redirectLocation(page.Response); location == "" {
+			return status, target, page.Body, nil
+		}
+	}
+	return status, target, data, nil
+}
+
+func setQuery(rawURL, query string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	parsed.RawQuery = query
+	return parsed.String()
+}
+
+// reports whether a URL is the login page, ignoring its query
+func (s *session) isLoginPage(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || s.loginURL == nil {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, s.loginURL.Host) && parsed.Path == s.loginURL.Path
+}
+
+// reports whether a fetched page shows the session has ended: it redirects to the login page or, if set, contains
+// LoggedOutText. The login page itself never does.
+func (s *session) loggedOut(pageURL string, result *fetchResult) bool {
+	if s.loginConfig == nil || s.isLoginPage(pageURL) {
+		return false
+	}
+	if location := redirectLocation(result.Response); location != "" && s.isLoginPage(resolveReference(pageURL, location)) {
+		return true
+	}
+	return s.loginConfig.LoggedOutText != "" && bytes.Contains(result.Body, []byte(s.loginConfig.LoggedOutText))
+}
+
+// logs back in after a page fetched at fetchedAt found the session had ended, reporting whether to fetch it again.
+// Pages that find it ended at the same time share a single login. After maxLoginFailures in a row, it stops trying.
+func (s *session) relogin(ctx context.Context, client *http.Client, logger Logger, fetchedAt time.Time) bool {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if s.loggedIn.After(fetchedAt) {
+		return true // another page's fetch already logged back in
+	}
+	if s.loginFailures >= maxLoginFailures {
+		return false
+	}
+
+	logger.Printf("(i) Logged out of %s. Logging back in", s.loginConfig.URL)
+	if err := s.login(ctx, client); err != nil {
+		s.loginFailures++
+		logger.Printf("[!] Failed to log back in: %v", err)
+		if s.loginFailures == maxLoginFailures {
+			logger.Printf("[!] Giving up logging back in after %d failures. The rest of the crawl may be logged out", maxLoginFailures)
+		}
+		return false
+	}
+	s.loginFailures = 0
+	return true
+}
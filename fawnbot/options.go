@@ -0,0 +1,100 @@
+package fawnbot
+
+/*
+| - - options.go - -
+| Functional options for configuring a Crawler, and the interfaces they accept
+*/
+
+import (
+	"net/http"
+)
+
+type Option func(*Crawler)
+
+// Sets the base program config. The crawl config's overrides are still layered on top.
+func WithProgramConfig(config ProgramConfig) Option {
+	return func(c *Crawler) {
+		c.config = config
+	}
+}
+
+// Sets the HTTP client used for every request. Redirects are never followed, so they can be recorded.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Crawler) {
+		c.client = newNoRedirectClient(client)
+	}
+}
+
+// Sets where progress messages go. By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(c *Crawler) {
+		if logger == nil {
+			logger = discardLogger{}
+		}
+		c.logger = logger
+	}
+}
+
+// Limits the crawl to at most perSecond requests a second to each host, overriding the program and crawl configs
+func WithRateLimit(perSecond int) Option {
+	return func(c *Crawler) {
+		c.crawlConfig.MaxCrawlsPerSecond = &perSecond
+	}
+}
+
+// Sets which discovered URLs are followed, overriding the crawl config's Scope. By default, only those on the root's host.
+func WithScope(scope Scope) Option {
+	return func(c *Crawler) {
+		c.scope = scope
+	}
+}
+
+// Sets where interrupted crawls are checkpointed. By default, the program config's CheckpointDir.
+func WithStorage(storage Storage) Option {
+	return func(c *Crawler) {
+		c.storage = storage
+	}
+}
+
+// Sets the crawl's ID, which names its checkpoints. A crawl interrupted or killed mid-way resumes when run again with
+// the same ID. By default each site has its own ID, so its next crawl resumes it.
+func WithCrawlID(id string) Option {
+	return func(c *Crawler) {
+		c.crawlID = id
+	}
+}
+
+// Adds exporters, run in order once the crawl finishes
+func WithExporters(exporters ...Exporter) Option {
+	return func(c *Crawler) {
+		c.exporters = append(c.exporters, exporters...)
+	}
+}
+
+// - - -
+
+// Logger receives progress messages. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+// Scope decides which discovered URLs a crawl follows
+type Scope interface {
+	InScope(url string) bool
+}
+
+type ScopeFunc func(url string) bool
+
+func (f ScopeFunc) InScope(url string) bool {
+	return f(url)
+}
+
+// Keeps a crawl to URLs on the root's host (exactly, so not example.com.evil.com). See ScopeConfig for more control.
+func HostScope(root string) Scope {
+	scope, _ := (*ScopeConfig)(nil).compile(root) // can't fail without patterns
+	return scope
+}
@@ -0,0 +1,128 @@
+package fawnbot
+
+/*
+| - - pdf.go - -
+| Lightweight PDF reading: just enough to find a document's title and the URLs it links to
+*/
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+	pdfTitle  = regexp.MustCompile(`/Title\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+	pdfURI    = regexp.MustCompile(`/URI\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+)
+
+const maxPDFStreamSize = 1 << 20 // decompressed streams are only searched up to this size
+
+// returns a PDF's title and the URIs of its links. Compressed object streams are searched too, but
+// nothing is decrypted, so encrypted PDFs only give what's in plain text.
+func parsePDF(data []byte) (string, []string) {
+	sources := [][]byte{data}
+	for _, match := range pdfStream.FindAllSubmatch(data, -1) {
+		reader, err := zlib.NewReader(bytes.NewReader(match[1]))
+		if err != nil {
+			continue
+		}
+		if inflated, err := io.ReadAll(io.LimitReader(reader, maxPDFStreamSize)); len(inflated) > 0 && (err == nil || err == io.ErrUnexpectedEOF) {
+			sources = append(sources, inflated)
+		}
+		reader.Close()
+	}
+
+	title := ""
+	var links []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		if match := pdfTitle.FindSubmatch(source); match != nil && title == "" {
+			title = strings.TrimSpace(decodePDFString(match[1]))
+		}
+		for _, match := range pdfURI.FindAllSubmatch(source, -1) {
+			if link := strings.TrimSpace(decodePDFString(match[1])); link != "" && !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+
+	return title, links
+}
+
+// decodes a PDF literal string, (like this), or hex string, <6C696B652074686973>
+func decodePDFString(raw []byte) string {
+	var decoded []byte
+	if raw[0] == '<' {
+		hex := strings.Join(strings.Fields(string(raw[1:len(raw)-1])), "")
+		if len(hex)%2 == 1 {
+			hex += "0"
+		}
+		for i := 0; i+1 < len(hex); i += 2 {
+			b, _ := strconv.ParseUint(hex[i:i+2], 16, 8)
+			decoded = append(decoded, byte(b))
+		}
+	} else {
+		decoded = unescapePDFLiteral(raw[1 : len(raw)-1])
+	}
+
+	// UTF-16BE, marked by a byte order mark. Otherwise PDFDocEncoding, which matches Latin-1 closely enough.
+	if len(decoded) >= 2 && decoded[0] == 0xFE && decoded[1] == 0xFF {
+		units := make([]uint16, 0, len(decoded)/2)
+		for i := 2; i+1 < len(decoded); i += 2 {
+			units = append(units, uint16(decoded[i])<<8|uint16(decoded[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(decoded))
+	for i, b := range decoded {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func unescapePDFLiteral(s []byte) []byte {
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n': // a line continuation
+			if c == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		default:
+			if c >= '0' && c <= '7' {
+				end := i + 1
+				for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+					end++
+				}
+				value, _ := strconv.ParseUint(string(s[i:end]), 8, 8)
+				out = append(out, byte(value))
+				i = end - 1
+			} else {
+				out = append(out, c) // \( \) \\
+			}
+		}
+	}
+	return out
+}
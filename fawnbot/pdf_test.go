@@ -0,0 +1,62 @@
+package fawnbot
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+func TestDecodePDFString(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`(Annual Report)`, "Annual Report"},
+		{`(Prices \(2025\) \\ more)`, `Prices (2025) \ more`},
+		{`(line\none)`, "line\none"},
+		{`(split \` + "\n" + `line)`, "split line"},
+		{`(caf\351)`, "café"},
+		{`(\101\102C)`, "ABC"},
+		{`<416E6E75616C>`, "Annual"},
+		{`<41 6E 6E>`, "Ann"},
+		{`<414>`, "A@"}, // an odd digit is followed by 0
+		{`<FEFF00500072006900780020>`, "Prix "},
+		{"<FEFF" + "D83DDE00" + ">", "😀"},
+	}
+	for _, test := range tests {
+		if got := decodePDFString([]byte(test.raw)); got != test.want {
+			t.Errorf("decodePDFString(%q) = %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestParsePDF(t *testing.T) {
+	// links in a compressed object stream, as most PDFs keep them
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write([]byte(`<< /Type /Annot /A << /S /URI /URI (https://example.com/compressed) >> >>`))
+	writer.Close()
+
+	pdf := fmt.Sprintf("%%PDF-1.7\n"+
+		"1 0 obj << /Title <FEFF005200650070006F00720074> /Author (Someone) >> endobj\n"+
+		"2 0 obj << /A << /S /URI /URI (https://example.com/plain) >> >> endobj\n"+
+		"3 0 obj << /A << /S /URI /URI <68747470733A2F2F6578616D706C652E636F6D2F686578> >> >> endobj\n"+
+		"4 0 obj << /A << /S /URI /URI (https://example.com/plain) >> >> endobj\n"+
+		"5 0 obj << /Filter /FlateDecode >> stream\n%s\nendstream endobj\n%%EOF", compressed.Bytes())
+
+	title, links := parsePDF([]byte(pdf))
+	if title != "Report" {
+		t.Errorf("title = %q, want Report", title)
+	}
+	want := []string{"https://example.com/plain", "https://example.com/hex", "https://example.com/compressed"}
+	if fmt.Sprint(links) != fmt.Sprint(want) {
+		t.Errorf("links = %q, want %q", links, want)
+	}
+
+	// streams that aren't compressed, or are corrupt, are skipped
+	title, links = parsePDF([]byte("%PDF-1.4\nstream\nnot zlib\nendstream\n1 0 obj << /Title (Plain) >> endobj"))
+	if title != "Plain" || len(links) != 0 {
+		t.Errorf("uncompressed stream: title %q, links %q", title, links)
+	}
+}
@@ -0,0 +1,196 @@
+package fawnbot
+
+/*
+| - - plugins.go - -
+| Hooks for custom checks, run by the crawler without forking scanPage
+|
+| A plugin implements Plugin plus any of the hook interfaces. For example, a check for a tracking snippet:
+|
+|	type gtmCheck struct{}
+|	func (gtmCheck) Name() string { return "GTM" }
+|	func (gtmCheck) OnPage(page *Page) error {
+|		found := bytes.Contains(page.Body, []byte("GTM-ABC123"))
+|		page.SetColumn("Has GTM", found)
+|		if !found {
+|			page.AddIssue("missing GTM container")
+|		}
+|		return nil
+|	}
+|
+|	crawler := NewCrawler(crawlConfig, WithPlugins(gtmCheck{}))
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type Plugin interface {
+	Name() string
+}
+
+// Called once before the first URL is fetched. An error aborts the crawl.
+type CrawlStartHook interface {
+	OnCrawlStart(ctx context.Context, root string) error
+}
+
+// Called when a new in-scope URL is first found and queued
+type URLDiscoveredHook interface {
+	OnURLDiscovered(url string, foundOn string)
+}
+
+// Called for every fetched page, after the built-in metrics are collected. Errors are logged and recorded as issues.
+type PageHook interface {
+	OnPage(page *Page) error
+}
+
+// Called once the crawl and post-crawl metrics are complete, before exporters run. Errors are returned from Run.
+type CrawlEndHook interface {
+	OnCrawlEnd(ctx context.Context, result *CrawlResult) error
+}
+
+// A fetched page, as seen by plugins
+type Page struct {
+	URL      string
+	Response *http.Response // nil if the URL was unreachable. The body has already been read into Body
+	Header   http.Header
+	Body     []byte     // nil if no plugin reads it, though custom plugins always do
+	Doc      *html.Node // the parsed DOM of 200 HTML responses, if a plugin reads it (custom plugins always do), otherwise nil
+	Object   *URLObject // the page's results, including any columns and issues added so far
+
+	isHTML   bool                    // a 200 HTML response, read by scanPage
+	visitors map[string]tokenVisitor // what scanning plugins gathered during scanPage's pass, by plugin name
+}
+
+// Built-in plugins that say whether they read a page's Body and Doc. Unless they implement it, scanning plugins read
+// neither and other plugins both.
+type pageReader interface {
+	readsBody() bool
+	readsDoc() bool
+}
+
+// Adds a custom column to the page's results. Columns are exported alongside the built-in metrics.
+func (p *Page) SetColumn(name string, value interface{}) {
+	if p.Object.Columns == nil {
+		p.Object.Columns = make(map[string]string)
+	}
+	p.Object.Columns[name] = fmt.Sprint(value)
+}
+
+// Records an issue against the page
+func (p *Page) AddIssue(issue string) {
+	p.Object.Issues = append(p.Object.Issues, issue)
+}
+
+// Adds plugins, whose hooks run in the order given
+func WithPlugins(plugins ...Plugin) Option {
+	return func(c *Crawler) {
+		c.plugins = append(c.plugins, plugins...)
+	}
+}
+
+// - - -
+
+// returns the plugins behind the crawler's own checks, such as structured data, extractors and search rules
+func builtinPlugins(c *Crawler) []Plugin {
+	crawlConfig := c.crawlConfig
+	config := crawlConfig.EffectiveConfig(c.config)
+	plugins := []Plugin{
+		structuredDataPlugin{},
+		socialPlugin{checker: c.checker},
+		&hreflangPlugin{fromSitemaps: config.HreflangSitemaps, client: c.scheduled, logger: c.logger},
+		imagesPlugin{checker: c.checker, fetch: config.CheckImages, maxKB: config.MaxImageKB},
+	}
+	if config.CheckResources {
+		plugins = append(plugins, newResourcesPlugin(c.checker))
+	}
+	if len(crawlConfig.Extractors) > 0 {
+		plugins = append(plugins, &extractorPlugin{extractors: crawlConfig.Extractors})
+	}
+	if len(crawlConfig.SearchRules) > 0 {
+		plugins = append(plugins, &searchPlugin{rules: crawlConfig.SearchRules})
+	}
+	return plugins
+}
+
+// returns whether any plugin reads pages' bodies, and whether any reads their DOM
+func (c *Crawler) pageReads() (bool, bool) {
+	body, doc := false, false
+	for _, plugin := range c.plugins {
+		if reader, ok := plugin.(pageReader); ok {
+			body, doc = body || reader.readsBody(), doc || reader.readsDoc()
+		} else if _, ok := plugin.(scanningPlugin); !ok {
+			return true, true
+		}
+	}
+	return body, doc
+}
+
+// returns new visitors for the scanning plugins to gather a page's data in scanPage's pass, by plugin name
+func (c *Crawler) pageVisitors(pageURL string) map[string]tokenVisitor {
+	visitors := make(map[string]tokenVisitor)
+	for _, plugin := range c.plugins {
+		if scanning, ok := plugin.(scanningPlugin); ok {
+			visitors[plugin.Name()] = scanning.newVisitor(pageURL)
+		}
+	}
+	return visitors
+}
+
+func (c *Crawler) runCrawlStartHooks(ctx context.Context, root string) error {
+	for _, plugin := range c.plugins {
+		if hook, ok := plugin.(CrawlStartHook); ok {
+			if err := hook.OnCrawlStart(ctx, root); err != nil {
+				return fmt.Errorf("plugin %s: %v", plugin.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) runURLDiscoveredHooks(url, foundOn string) {
+	for _, plugin := range c.plugins {
+		if hook, ok := plugin.(URLDiscoveredHook); ok {
+			hook.OnURLDiscovered(url, foundOn)
+		}
+	}
+}
+
+func (c *Crawler) runPageHooks(page *Page) {
+	for _, plugin := range c.plugins {
+		if hook, ok := plugin.(PageHook); ok {
+			if err := hook.OnPage(page); err != nil {
+				c.logger.Printf("[!] Plugin %s failed on %s: %v", plugin.Name(), page.URL, err)
+				page.AddIssue(fmt.Sprintf("plugin %s failed: %v", plugin.Name(), err))
+			}
+		}
+	}
+}
+
+func (c *Crawler) runCrawlEndHooks(ctx context.Context, result *CrawlResult) []error {
+	var errs []error
+	for _, plugin := range c.plugins {
+		if hook, ok := plugin.(CrawlEndHook); ok {
+			if err := hook.OnCrawlEnd(ctx, result); err != nil {
+				c.logger.Printf("[!] Plugin %s failed at crawl end: %v", plugin.Name(), err)
+				errs = append(errs, fmt.Errorf("plugin %s: %v", plugin.Name(), err))
+			}
+		}
+	}
+	return errs
+}
+
+// adds the names of a URLObject's custom columns to a set of them
+func addKeys[V any](set map[string]bool, columns map[string]V) {
+	for column := range columns {
+		set[column] = true
+	}
+}
+
+func joinIssues(issues []string) string {
+	return strings.Join(issues, "; ")
+}
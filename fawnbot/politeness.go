@@ -0,0 +1,221 @@
+package fawnbot
+
+/*
+| - - politeness.go - -
+| Per-host request scheduling: a minimum interval between requests to each host, slowing down for hosts that struggle
+*/
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	slowdownStep  = 250 * time.Millisecond // the least interval a struggling host is slowed to
+	maxSlowdown   = 10 * time.Second       // adaptive slowdowns never space requests further apart than this
+	maxRetryAfter = 2 * time.Minute        // longer Retry-After headers are capped to this
+	slowLatency   = 500 * time.Millisecond // latencies below this never count as a host struggling
+)
+
+// hostScheduler spaces out requests to each host, with one request in flight per host at a time.
+// Requests to different hosts don't wait for each other. Safe for concurrent use.
+type hostScheduler struct {
+	adaptive bool // slows down hosts whose latency or error rate rises
+	logger   Logger
+
+	mu    sync.Mutex
+	hosts map[string]*hostSchedule
+}
+
+type hostSchedule struct {
+	slot     chan struct{} // held while a request to the host is in flight
+	started  time.Time     // when the current (or last) request started
+	next     time.Time     // when the next request may start
+	min      time.Duration
+	interval time.Duration // min, or more while the host is slowed down
+
+	responses int
+	recent    time.Duration // fast-moving average of response latency
+	baseline  time.Duration // slow-moving average, which recent is compared against
+	errorRate float64       // moving average of failed requests: unreachable, 429s and 5xxs
+}
+
+func newHostScheduler(adaptive bool, logger Logger) *hostScheduler {
+	return &hostScheduler{adaptive: adaptive, logger: logger, hosts: make(map[string]*hostSchedule)}
+}
+
+// returns the host's schedule, starting it at minInterval if it's new. A longer minInterval than the host's raises it,
+// e.g. once its robots.txt sets a Crawl-delay.
+func (s *hostScheduler) host(host string, minInterval time.Duration) *hostSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &hostSchedule{slot: make(chan struct{}, 1), min: minInterval, interval: minInterval}
+		s.hosts[host] = h
+	}
+	if minInterval > h.min {
+		h.min, h.interval = minInterval, max(h.interval, minInterval)
+	}
+	return h
+}
+
+// waits until a request to host may start, then reserves it. Every successful acquire must be followed by a release.
+// minInterval is the host's configured interval, e.g. from MaxCrawlsPerSecond and its Crawl-delay.
+func (s *hostScheduler) acquire(ctx context.Context, host string, minInterval time.Duration) error {
+	h := s.host(host, minInterval)
+	select {
+	case h.slot <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	wait := time.Until(h.next)
+	s.mu.Unlock()
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			<-h.slot
+			return ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	h.started = time.Now()
+	h.next = h.started.Add(h.interval)
+	s.mu.Unlock()
+	return nil
+}
+
+// records how a request to host went, adjusting its interval if adaptive, and frees the host for its next request.
+// status is 0 if the host was unreachable.
+func (s *hostScheduler) release(host string, status int, latency time.Duration, header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.hosts[host]
+	defer func() { <-h.slot }()
+
+	if !s.adaptive {
+		return
+	}
+
+	previous := h.interval
+	h.observe(status, latency)
+	h.next = h.started.Add(h.interval)
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		if retryAfter := parseRetryAfter(header.Get("Retry-After"), time.Now()); retryAfter > 0 {
+			if until := time.Now().Add(min(retryAfter, maxRetryAfter)); until.After(h.next) {
+				h.next = until
+			}
+		}
+	}
+
+	if h.interval > previous {
+		s.logger.Printf("(i) %s is struggling. Slowing to one request every %s", host, h.interval)
+	} else if h.interval == h.min && previous > h.min {
+		s.logger.Printf("(i) %s has recovered. Back to one request every %s", host, h.interval)
+	}
+}
+
+// updates the host's averages with a response, slowing down if its error rate or latency has risen, or speeding
+// back up towards its minimum interval if not
+func (h *hostSchedule) observe(status int, latency time.Duration) {
+	failed := status == 0 || status == http.StatusTooManyRequests || status >= 500
+	if failed {
+		h.errorRate = h.errorRate*0.8 + 0.2
+	} else {
+		h.errorRate *= 0.8
+	}
+
+	if status != 0 {
+		h.responses++
+		if h.responses == 1 {
+			h.recent, h.baseline = latency, latency
+		} else {
+			h.recent = (h.recent*7 + latency*3) / 10
+			h.baseline = (h.baseline*19 + latency) / 20
+		}
+	}
+
+	// a lone error doesn't slow a host down, but a run of them does. So does latency doubling.
+	slow := (failed && h.errorRate > 0.25) || (h.responses >= 5 && h.recent > max(2*h.baseline, slowLatency))
+	if slow {
+		h.interval = max(h.min, min(max(h.interval*2, slowdownStep), maxSlowdown))
+	} else if h.interval > h.min {
+		h.interval = max(h.min, h.interval*9/10)
+	}
+}
+
+// - - -
+
+// scheduledTransport sends each request once its host's turn comes, holding the host until the response body is closed.
+// It spaces out requests made outside the crawl's page fetches, such as logins, link checks and robots.txt files, with
+// the crawl's own.
+type scheduledTransport struct {
+	base      http.RoundTripper
+	scheduler *hostScheduler
+	interval  time.Duration // the least interval between requests to any host
+}
+
+// returns a copy of client whose requests wait for their host's turn in scheduler
+func newScheduledClient(client *http.Client, scheduler *hostScheduler, interval time.Duration) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	scheduled := *client
+	scheduled.Transport = &scheduledTransport{base: base, scheduler: scheduler, interval: interval}
+	return &scheduled
+}
+
+func (t *scheduledTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	host := strings.ToLower(request.URL.Host)
+	if err := t.scheduler.acquire(request.Context(), host, t.interval); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		t.scheduler.release(host, 0, 0, nil)
+		return nil, err
+	}
+	latency := time.Since(start)
+	response.Body = &releasingBody{ReadCloser: response.Body, release: sync.OnceFunc(func() {
+		t.scheduler.release(host, response.StatusCode, latency, response.Header)
+	})}
+	return response, nil
+}
+
+// releasingBody frees its request's host once closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// parses a Retry-After header, given in seconds or as an HTTP date. Returns 0 if there isn't one.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package fawnbot
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"-3", 0},
+		{"Sun, 01 Jun 2025 12:00:30 GMT", 30 * time.Second},
+		{"Sun, 01 Jun 2025 11:00:00 GMT", 0}, // in the past
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		if got := parseRetryAfter(test.value, now); got != test.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestHostScheduleObserve(t *testing.T) {
+	h := &hostSchedule{min: 100 * time.Millisecond, interval: 100 * time.Millisecond}
+
+	// a lone error doesn't slow the host down
+	h.observe(500, 50*time.Millisecond)
+	if h.interval != h.min {
+		t.Fatalf("after one error: interval %v, want %v", h.interval, h.min)
+	}
+
+	// a run of them does, up to maxSlowdown
+	h.observe(503, 50*time.Millisecond)
+	if h.interval != slowdownStep {
+		t.Errorf("after two errors: interval %v, want %v", h.interval, slowdownStep)
+	}
+	for i := 0; i < 20; i++ {
+		h.observe(0, 0)
+	}
+	if h.interval != maxSlowdown {
+		t.Errorf("after a run of errors: interval %v, want %v", h.interval, maxSlowdown)
+	}
+
+	// then recovers towards its minimum
+	for i := 0; i < 100; i++ {
+		h.observe(200, 50*time.Millisecond)
+	}
+	if h.interval != h.min {
+		t.Errorf("after recovering: interval %v, want %v", h.interval, h.min)
+	}
+
+	// latency doubling slows it down too, but only once over slowLatency
+	fast := &hostSchedule{min: 100 * time.Millisecond, interval: 100 * time.Millisecond}
+	for i := 0; i < 5; i++ {
+		fast.observe(200, 10*time.Millisecond)
+	}
+	fast.observe(200, 200*time.Millisecond)
+	if fast.interval != fast.min {
+		t.Errorf("latency under slowLatency: interval %v, want %v", fast.interval, fast.min)
+	}
+	fast.observe(200, 5*time.Second)
+	if fast.interval <= fast.min {
+		t.Errorf("latency doubled: interval %v, want a slowdown", fast.interval)
+	}
+}
+
+// a scheduled request holds its host until its body is closed, and spaces the next one out
+func TestScheduledClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	scheduler := newHostScheduler(false, log.New(io.Discard, "", 0))
+	client := newScheduledClient(server.Client(), scheduler, 50*time.Millisecond)
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	host := extractHost(server.URL)
+	if err := scheduler.acquire(ctx, host, 0); err == nil {
+		t.Fatal("host acquired while a response was open")
+	}
+	response.Body.Close()
+
+	start := time.Now()
+	if err := scheduler.acquire(context.Background(), host, 0); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.release(host, 200, 0, nil)
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("next request waited %v, want about the 50ms interval", waited)
+	}
+}
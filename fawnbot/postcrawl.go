@@ -5,10 +5,9 @@ package fawnbot
 | Post-crawl analysis for the more complicated metrics of URLObjects.
 */
 
-// receiver function to fill in remaining data at end of crawl
-func (u URLObjectList) runPostCrawl() {
-
-	for url, obj := range u.URLObjects {
+// receiver function to fill in remaining data at end of crawl, one URLObject at a time
+func (u URLObjectList) runPostCrawl() error {
+	return u.ForEach(func(url string, obj *URLObject) error {
 		if obj.Inlinks == 0 {
 			obj.IsOrphan = true
 		}
@@ -16,13 +15,24 @@ func (u URLObjectList) runPostCrawl() {
 		// todo: code here
 
 		// 3. IsCanonicalIndexable, IsSelfCanonicalising
-		if _, ok := u.URLObjects[obj.Canonical]; ok {
+		canonical, ok, err := u.Get(obj.Canonical)
+		if err != nil {
+			return err
+		}
+		if ok {
 			if url == obj.Canonical {
 				obj.IsSelfCanonicalising = true
 			}
-			if u.URLObjects[obj.Canonical].Indexability {
+			if canonical.Indexability {
 				obj.IsCanonicalIndexable = true
 			}
 		}
-	}
+
+		// 4. hreflang, which needs every page's status and canonical
+		if err := u.validateHreflang(url, obj); err != nil {
+			return err
+		}
+
+		return u.put(url, obj)
+	})
 }
@@ -0,0 +1,273 @@
+package fawnbot
+
+/*
+| - - resources.go - -
+| Page resource audit: stylesheets, scripts, preloads and fonts, each fetched once per crawl
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	resourceStylesheet = "stylesheet"
+	resourceScript     = "script"
+	resourceFont       = "font"
+	resourcePreload    = "preload" // preloads of anything other than styles, scripts and fonts
+)
+
+// A stylesheet, script, preload or font used by a page
+type PageResource struct {
+	URL             string
+	Kind            string // stylesheet, script, font or preload
+	BlockedByRobots bool   // by the robots.txt of the resource's host
+	Fetched         LinkStatus
+}
+
+func (r PageResource) IsBroken() bool {
+	return isBrokenStatus(r.Fetched.Status)
+}
+
+var (
+	cssFontFace = regexp.MustCompile(`(?is)@font-face\s*{[^}]*}`)
+	cssURL      = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+)
+
+// returns the resources a page's HTML references, and the URLs of fonts declared in its inline styles
+func parseResources(pageURL string, body []byte) []PageResource {
+	v := newResourcesVisitor(pageURL)
+	scanPage(bytes.NewReader(body), v)
+	return v.resources
+}
+
+type resourcesVisitor struct {
+	pageURL   string
+	resources []PageResource
+	seen      map[string]bool // by URL
+}
+
+func newResourcesVisitor(pageURL string) *resourcesVisitor {
+	return &resourcesVisitor{pageURL: pageURL, seen: make(map[string]bool)}
+}
+
+func (v *resourcesVisitor) add(ref, kind string) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(strings.ToLower(ref), "data:") {
+		return
+	}
+	resource := PageResource{URL: resolveReference(v.pageURL, ref), Kind: kind}
+	if !v.seen[resource.URL] {
+		v.seen[resource.URL] = true
+		v.resources = append(v.resources, resource)
+	}
+}
+
+func (v *resourcesVisitor) visit(t *scanToken) {
+	switch t.Type {
+	case html.TextToken:
+		if parent := t.parent(); parent != nil && parent.Data == "style" {
+			for _, font := range fontURLs(t.Data) {
+				v.add(font, resourceFont)
+			}
+		}
+		return
+	case html.EndTagToken:
+		return
+	}
+	switch t.Data {
+	case "link":
+		rel := t.attr("rel")
+		switch {
+		case hasRel(rel, "stylesheet"):
+			v.add(t.attr("href"), resourceStylesheet)
+		case hasRel(rel, "preload") || hasRel(rel, "modulepreload"):
+			v.add(t.attr("href"), preloadKind(t.attr("as"), hasRel(rel, "modulepreload")))
+		}
+	case "script":
+		v.add(t.attr("src"), resourceScript)
+	}
+}
+
+func preloadKind(as string, module bool) string {
+	switch strings.ToLower(as) {
+	case "style":
+		return resourceStylesheet
+	case "script":
+		return resourceScript
+	case "font":
+		return resourceFont
+	}
+	if module {
+		return resourceScript
+	}
+	return resourcePreload
+}
+
+// returns the URLs in a stylesheet's @font-face rules, unresolved
+func fontURLs(css string) []string {
+	var urls []string
+	for _, rule := range cssFontFace.FindAllString(css, -1) {
+		for _, match := range cssURL.FindAllStringSubmatch(rule, -1) {
+			urls = append(urls, match[1])
+		}
+	}
+	return urls
+}
+
+func brokenResources(resources []PageResource) int {
+	count := 0
+	for _, resource := range resources {
+		if resource.IsBroken() {
+			count++
+		}
+	}
+	return count
+}
+
+// Writes every resource in a crawl as CSV, one row per page and resource
+func WriteResourcesCSV(w io.Writer, URLObjectList URLObjectList) error {
+	rows := newCSVRows(w)
+	if err := rows.write("URL", "Resource URL", "Kind", "Status", "Content Type", "Size (Bytes)", "Cache-Control", "Expires", "Blocked by Robots"); err != nil {
+		return err
+	}
+	err := URLObjectList.ForEach(func(url string, obj *URLObject) error {
+		for _, resource := range obj.Resources {
+			size := ""
+			if resource.Fetched.Size >= 0 {
+				size = fmt.Sprint(resource.Fetched.Size)
+			}
+			if err := rows.write(url, resource.URL, resource.Kind, resource.Fetched.Status, resource.Fetched.ContentType, size,
+				resource.Fetched.CacheControl, resource.Fetched.Expires, resource.BlockedByRobots); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rows.flush()
+}
+
+// Writes the crawl's resources as CSV (see WriteResourcesCSV)
+type ResourcesCSVExporter struct {
+	W io.Writer
+}
+
+func (e ResourcesCSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteResourcesCSV(e.W, result.URLObjectList)
+}
+
+// - - -
+
+// resourcesPlugin audits every 200 page's resources. Each resource is checked once, and each stylesheet is read once for fonts.
+type resourcesPlugin struct {
+	checker *linkChecker
+	mu      sync.Mutex
+	robots  map[string]*Robots  // by host. nil if the host has no robots.txt
+	fonts   map[string][]string // by stylesheet URL
+}
+
+func newResourcesPlugin(checker *linkChecker) *resourcesPlugin {
+	return &resourcesPlugin{checker: checker, robots: make(map[string]*Robots), fonts: make(map[string][]string)}
+}
+
+func (p *resourcesPlugin) Name() string { return "Resources" }
+
+func (p *resourcesPlugin) newVisitor(pageURL string) tokenVisitor {
+	return newResourcesVisitor(pageURL)
+}
+
+func (p *resourcesPlugin) OnPage(page *Page) error {
+	if !page.isHTML {
+		return nil
+	}
+	ctx := page.Response.Request.Context()
+
+	resources := scanned[*resourcesVisitor](page, p).resources
+	seen := make(map[string]bool)
+	for _, resource := range resources {
+		seen[resource.URL] = true
+	}
+
+	// fonts declared in linked stylesheets are used by the page too
+	for _, resource := range resources {
+		if resource.Kind != resourceStylesheet {
+			continue
+		}
+		for _, font := range p.stylesheetFonts(ctx, resource.URL) {
+			if !seen[font] {
+				seen[font] = true
+				resources = append(resources, PageResource{URL: font, Kind: resourceFont})
+			}
+		}
+	}
+
+	for i := range resources {
+		resources[i].Fetched = p.checker.check(ctx, resources[i].URL)
+		if robots := p.hostRobots(ctx, resources[i].URL); robots != nil {
+			resources[i].BlockedByRobots = isURLBlockedByRobots(resources[i].URL, *robots)
+		}
+	}
+	page.Object.Resources = resources
+
+	return nil
+}
+
+// returns the absolute URLs of the fonts a stylesheet declares
+func (p *resourcesPlugin) stylesheetFonts(ctx context.Context, stylesheet string) []string {
+	p.mu.Lock()
+	fonts, ok := p.fonts[stylesheet]
+	p.mu.Unlock()
+	if ok {
+		return fonts
+	}
+
+	// the stylesheet's check is made from the same request, so it isn't requested again
+	result, body := p.checker.get(ctx, stylesheet, -1)
+	if ctx.Err() != nil {
+		return nil // cancelled, so don't cache
+	}
+	if result.Status == 200 {
+		for _, font := range fontURLs(string(body)) {
+			fonts = append(fonts, resolveReference(stylesheet, font))
+		}
+	}
+
+	p.mu.Lock()
+	p.fonts[stylesheet] = fonts
+	p.mu.Unlock()
+
+	return fonts
+}
+
+func (p *resourcesPlugin) hostRobots(ctx context.Context, resourceURL string) *Robots {
+	host := extractHost(resourceURL)
+
+	p.mu.Lock()
+	robots, ok := p.robots[host]
+	p.mu.Unlock()
+	if ok {
+		return robots
+	}
+
+	if found, err := getRobots(ctx, p.checker.client, resourceURL); err == nil {
+		robots = &found
+	} else if ctx.Err() != nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	p.robots[host] = robots
+	p.mu.Unlock()
+
+	return robots
+}
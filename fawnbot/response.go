@@ -0,0 +1,169 @@
+package fawnbot
+
+/*
+| - - response.go - -
+| Response metadata for fetched pages: timings, sizes and headers
+*/
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type ResponseMeta struct {
+	TTFB            time.Duration // from sending the request to the first byte of the response
+	DownloadTime    time.Duration // from sending the request to the last byte of the body
+	TransferSize    int64         // the body as sent, possibly compressed
+	Size            int64         // the body once decompressed
+	ContentType     string        `json:",omitempty"` // the media type, without parameters
+	Charset         string        `json:",omitempty"` // from the Content-Type header, or the HTML's <meta> tags
+	ContentEncoding string        `json:",omitempty"`
+	LastModified    string        `json:",omitempty"`
+	ETag            string        `json:",omitempty"`
+	CacheControl    string        `json:",omitempty"`
+	ServerIP        string        `json:",omitempty"`
+	Truncated       bool          `json:",omitempty"` // the body was longer than the maximum, so only its start was read
+	BodySkipped     bool          `json:",omitempty"` // the body wasn't read, as its content type isn't parsed
+}
+
+// describes how much of the body was read, for exports
+func (m ResponseMeta) bodyRead() string {
+	switch {
+	case m.BodySkipped:
+		return "skipped"
+	case m.Truncated:
+		return "truncated"
+	}
+	return "full"
+}
+
+// sizes are -1 when unknown, which is exported as blank
+func sizeValue(size int64) interface{} {
+	if size < 0 {
+		return ""
+	}
+	return size
+}
+
+// fills in the metadata taken from a response's headers
+func (m *ResponseMeta) readHeaders(header http.Header) {
+	m.ContentEncoding = header.Get("Content-Encoding")
+	m.LastModified = header.Get("Last-Modified")
+	m.ETag = header.Get("ETag")
+	m.CacheControl = header.Get("Cache-Control")
+
+	if mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		m.ContentType = strings.ToLower(mediaType)
+		m.Charset = strings.ToLower(params["charset"])
+	}
+}
+
+var metaCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?([a-zA-Z0-9_\-:.]+)`)
+
+// reads the charset of HTML served without one from its <meta> tags, which browsers look for in the first 1024 bytes
+func (m *ResponseMeta) sniffCharset(body []byte) {
+	if m.Charset != "" || m.ContentType != "text/html" {
+		return
+	}
+	if len(body) > 1024 {
+		body = body[:1024]
+	}
+	if match := metaCharset.FindSubmatch(body); match != nil {
+		m.Charset = strings.ToLower(string(match[1]))
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// counts the bytes read through it, to measure transfer sizes
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompresses a body sent with a Content-Encoding, up to limit bytes (0 for no limit).
+// Bodies that fail to decompress are returned as sent, but cut-off bodies return as much as could be decompressed.
+func decodeBody(raw []byte, encoding string, limit int64) []byte {
+	var reader io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		// usually zlib-wrapped, but some servers send raw deflate
+		if reader, err = zlib.NewReader(bytes.NewReader(raw)); err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(raw)), nil
+		}
+	default:
+		return raw
+	}
+	if err != nil {
+		return raw
+	}
+	defer reader.Close()
+
+	var limited io.Reader = reader
+	if limit > 0 {
+		limited = io.LimitReader(reader, limit+1)
+	}
+	decoded, err := io.ReadAll(limited)
+	if err != nil && (err != io.ErrUnexpectedEOF || len(decoded) == 0) {
+		return raw
+	}
+	return decoded
+}
+
+// reports whether a media type is parsed as HTML. Responses without a Content-Type are assumed to be HTML.
+func isHTMLType(contentType string) bool {
+	switch contentType {
+	case "", "text/html", "application/xhtml+xml":
+		return true
+	}
+	return false
+}
+
+// guesses whether a URL is meant to serve a web page, e.g. /about/, /about and /about.html, but not /brochure.pdf
+func looksLikeHTMLURL(rawURL string) bool {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		rawURL = rawURL[i+3:]
+	}
+	if i := strings.Index(rawURL, "/"); i >= 0 {
+		rawURL = rawURL[i:]
+	} else {
+		return true // the root
+	}
+
+	switch strings.ToLower(path.Ext(rawURL)) {
+	case "", ".html", ".htm", ".xhtml", ".php", ".asp", ".aspx", ".jsp", ".cfm", ".shtml":
+		return true
+	}
+	return false
+}
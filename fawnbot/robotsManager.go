@@ -6,7 +6,10 @@ package fawnbot
 */
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -15,7 +18,7 @@ import (
 type Robots struct {
 	Agents     []UserAgent
 	Sitemaps   []string
-	CrawlDelay int
+	CrawlDelay float64 // seconds, which may be fractional
 }
 
 type UserAgent struct {
@@ -61,8 +64,8 @@ func parseRobots(robotsFile string) Robots {
 		case "sitemap":
 			robots.Sitemaps = append(robots.Sitemaps, val)
 		case "crawl-delay":
-			delay, err := strconv.Atoi(val)
-			if err == nil {
+			delay, err := strconv.ParseFloat(val, 64)
+			if err == nil && delay >= 0 && !math.IsInf(delay, 0) {
 				robots.CrawlDelay = delay
 			}
 		}
@@ -83,42 +86,84 @@ func extractRootURL(inputURL string) (string, error) {
 	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host), nil
 }
 
-func getRobots(url string) (Robots, error) {
+func getRobots(ctx context.Context, client *http.Client, url string) (Robots, error) {
 	pruned, err := extractRootURL(url)
 	if err != nil {
 		return Robots{}, err
 	}
 
 	robotsURL := pruned + "/robots.txt"
-	robotsFile, status, _, err := fetchURLQuick(robotsURL)
-
-	if status == 200 {
-		fmt.Printf("(i) Found robots file at %s\n", robotsURL)
-		return parseRobots(robotsFile), nil
-	} else {
-		fmt.Printf("[!] Could not find robots file at %s\n", robotsURL)
-		return Robots{}, err
+	robotsFile, status, _, err := fetchURL(ctx, client, robotsURL)
+	if err != nil {
+		return Robots{}, fmt.Errorf("could not fetch robots file at %s: %v", robotsURL, err)
 	}
+	if status != 200 {
+		return Robots{}, fmt.Errorf("could not find robots file at %s", robotsURL)
+	}
+
+	return parseRobots(robotsFile), nil
 }
 
 func isURLBlockedByRobots(url string, robots Robots) bool {
-	url = strings.ToLower(url)
+	blocked, _, _ := matchRobotsRule(url, robots)
+	return blocked
+}
+
+// returns whether a URL is blocked, and the user-agent and rule that decided it (if any)
+func matchRobotsRule(rawURL string, robots Robots) (bool, string, string) {
+	target := strings.ToLower(rawURL)
 
 	for _, agent := range robots.Agents {
 		if agent.Name == "*" || strings.Contains("fawnbot", strings.ToLower(agent.Name)) {
 			for _, allow := range agent.Allow {
-				if strings.HasPrefix(url, allow) {
-					return false
+				if strings.HasPrefix(target, allow) {
+					return false, agent.Name, "Allow: " + allow
 				}
 			}
 
 			for _, disallow := range agent.Disallow {
-				if disallow == "/" || strings.HasPrefix(url, disallow) {
-					return true
+				if disallow == "/" || strings.HasPrefix(target, disallow) {
+					return true, agent.Name, "Disallow: " + disallow
 				}
 			}
 		}
 	}
 
-	return false
+	return false, "", ""
+}
+
+type RobotsTestResult struct {
+	URL        string
+	RobotsURL  string
+	Found      bool   // whether the site has a robots.txt
+	Blocked    bool   // whether fawnbot may not crawl the URL
+	Agent      string // the user-agent group that decided it
+	Rule       string // the rule that decided it
+	CrawlDelay float64
+	Sitemaps   []string
+}
+
+// Fetches a site's robots.txt and reports whether fawnbot may crawl the given URL. Cancelling ctx stops the fetch.
+func TestRobots(ctx context.Context, rawURL string) (RobotsTestResult, error) {
+	root, err := extractRootURL(rawURL)
+	if err != nil {
+		return RobotsTestResult{}, err
+	}
+	result := RobotsTestResult{URL: rawURL, RobotsURL: root + "/robots.txt"}
+
+	robotsFile, status, _, err := fetchURL(ctx, defaultClient, result.RobotsURL)
+	if err != nil {
+		return result, fmt.Errorf("failed to fetch robots file: %v", err)
+	}
+	if status != 200 {
+		return result, nil
+	}
+
+	robots := parseRobots(robotsFile)
+	result.Found = true
+	result.Blocked, result.Agent, result.Rule = matchRobotsRule(rawURL, robots)
+	result.CrawlDelay = robots.CrawlDelay
+	result.Sitemaps = robots.Sitemaps
+
+	return result, nil
 }
@@ -0,0 +1,36 @@
+package fawnbot
+
+import "testing"
+
+// robots test reports the rule the crawler itself goes by
+func TestMatchRobotsRule(t *testing.T) {
+	robots := parseRobots("User-agent: *\nAllow: https://example.com/open\nDisallow: https://example.com/private\n\nUser-agent: otherbot\nCrawl-delay: 1.5\n")
+	tests := []struct {
+		url         string
+		wantBlocked bool
+		wantRule    string
+	}{
+		{"https://example.com/", false, ""},
+		{"https://example.com/open/page", false, "Allow: https://example.com/open"},
+		{"https://EXAMPLE.com/Private/page", true, "Disallow: https://example.com/private"},
+	}
+	for _, test := range tests {
+		blocked, agent, rule := matchRobotsRule(test.url, robots)
+		if blocked != test.wantBlocked || rule != test.wantRule {
+			t.Errorf("matchRobotsRule(%s) = %v, %q, want %v, %q", test.url, blocked, rule, test.wantBlocked, test.wantRule)
+		}
+		if rule != "" && agent != "*" {
+			t.Errorf("matchRobotsRule(%s): agent %q, want *", test.url, agent)
+		}
+		if isURLBlockedByRobots(test.url, robots) != blocked {
+			t.Errorf("%s: crawler and robots test disagree", test.url)
+		}
+	}
+	if robots.CrawlDelay != 1.5 {
+		t.Errorf("CrawlDelay = %v, want 1.5", robots.CrawlDelay)
+	}
+
+	if blocked, _, rule := matchRobotsRule("https://example.com/any", parseRobots("User-agent: *\nDisallow: /\n")); !blocked || rule != "Disallow: /" {
+		t.Errorf("Disallow: / gave %v, %q", blocked, rule)
+	}
+}
@@ -0,0 +1,247 @@
+package fawnbot
+
+/*
+| - - scan.go - -
+| Single-pass, streaming page parsing: one tokenizer pass over the body for links, meta and headings, and for what
+| the built-in plugins read (structured data, social tags, hreflang, images, resources and visible text). No DOM is
+| built unless a plugin reads one, e.g. extractors' CSS and XPath queries or a custom plugin.
+*/
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// What the crawler reads from every HTML page
+type pageScan struct {
+	Indexable       bool
+	NoIndex         bool
+	Canonical       string
+	MetaDescription string
+	MetaTitle       string
+	H1              string
+	Links           []string // every <a href>, unresolved and in document order
+}
+
+// An element open around a token
+type scanElement struct {
+	Data string // the tag name
+	Attr []html.Attribute
+}
+
+func (e *scanElement) attr(key string) string {
+	for _, attr := range e.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func (e *scanElement) has(key string) bool {
+	for _, attr := range e.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// A start tag, end tag or text, with the elements open around it. End tags are given for every element closed, even
+// implicitly, and carry the element's attributes.
+type scanToken struct {
+	Type         html.TokenType // StartTagToken, SelfClosingTagToken, EndTagToken or TextToken
+	*scanElement                // the element started or ended, or the text (as Data)
+	Open         []*scanElement // the elements the token is in, innermost last. Not including its own
+	InHead       bool           // in the page's <head>, whether or not it's in the HTML
+}
+
+// the element the token is directly in, or nil at the top level
+func (t *scanToken) parent() *scanElement {
+	if len(t.Open) == 0 {
+		return nil
+	}
+	return t.Open[len(t.Open)-1]
+}
+
+// Gathers what a plugin needs from a page during scanPage's pass
+type tokenVisitor interface {
+	visit(t *scanToken) // called for every token, in document order
+}
+
+// Built-in plugins that read a page during scanPage's pass, instead of parsing it again in OnPage
+type scanningPlugin interface {
+	Plugin
+	newVisitor(pageURL string) tokenVisitor
+}
+
+// elements without an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "basefont": true, "bgsound": true, "br": true, "col": true, "embed": true, "frame": true,
+	"hr": true, "img": true, "input": true, "keygen": true, "link": true, "meta": true, "param": true, "source": true,
+	"track": true, "wbr": true,
+}
+
+// elements html.Parse keeps in the <head>. Any other element, or text, starts the body.
+var headElements = map[string]bool{
+	"html": true, "head": true, "base": true, "basefont": true, "bgsound": true, "link": true, "meta": true,
+	"noframes": true, "noscript": true, "script": true, "style": true, "template": true, "title": true,
+}
+
+// head elements whose text is part of the head, rather than starting the body
+var headTextElements = map[string]bool{"title": true, "script": true, "style": true, "noscript": true, "noframes": true, "template": true}
+
+// elements closed by the start of another of their kind, e.g. <li>one<li>two
+var siblingElements = map[string]string{"p": "p", "li": "li", "dt": "dd", "dd": "dt", "option": "option", "tr": "tr", "td": "th", "th": "td"}
+
+// tokenizes an HTML body as it's read from r, returning what the crawler needs from it. The visitors see every token
+// in the same pass.
+func scanPage(r io.Reader, visitors ...tokenVisitor) (pageScan, error) {
+	scan := pageScan{Indexable: true}
+	tokenizer := html.NewTokenizer(r)
+	var open []*scanElement
+	inHead := true
+	firstChild := "" // title or h1, while waiting for its first child. The legacy parser read its first child's Data
+
+	emit := func(t *scanToken) {
+		for _, v := range visitors {
+			v.visit(t)
+		}
+	}
+	// closes the elements from i in, innermost first
+	closeFrom := func(i int) {
+		for len(open) > i {
+			element := open[len(open)-1]
+			open = open[:len(open)-1]
+			if element.Data == "head" {
+				inHead = false
+			}
+			emit(&scanToken{Type: html.EndTagToken, scanElement: element, Open: open, InHead: inHead})
+		}
+	}
+	// the body starts, closing the <head> if it's open
+	leaveHead := func() {
+		inHead = false
+		for i, element := range open {
+			if element.Data == "head" {
+				closeFrom(i)
+				return
+			}
+		}
+	}
+	inForeign := func() bool {
+		for _, element := range open {
+			if element.Data == "svg" || element.Data == "math" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			closeFrom(0)
+			if err := tokenizer.Err(); err != io.EOF {
+				return scan, err
+			}
+			return scan, nil
+		}
+		token := tokenizer.Token()
+
+		if firstChild != "" && tokenType != html.DoctypeToken {
+			var data string
+			if tokenType != html.EndTagToken {
+				data = token.Data // text, comment or tag name
+			}
+			if firstChild == "title" && scan.MetaTitle == "" {
+				scan.MetaTitle = data
+			} else if firstChild == "h1" && scan.H1 == "" {
+				scan.H1 = data
+			}
+			firstChild = ""
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			if inHead && strings.TrimSpace(token.Data) != "" && (len(open) == 0 || !headTextElements[open[len(open)-1].Data]) {
+				leaveHead()
+			}
+			emit(&scanToken{Type: html.TextToken, scanElement: &scanElement{Data: token.Data}, Open: open, InHead: inHead})
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name := token.Data
+			if inHead && !headElements[name] {
+				leaveHead()
+			}
+			if sibling, ok := siblingElements[name]; ok && len(open) > 0 {
+				if top := open[len(open)-1].Data; top == name || top == sibling {
+					closeFrom(len(open) - 1)
+				}
+			}
+			element := &scanElement{Data: name, Attr: token.Attr}
+			emit(&scanToken{Type: tokenType, scanElement: element, Open: open, InHead: inHead})
+			scan.read(element)
+			if (name == "title" && scan.MetaTitle == "") || (name == "h1" && scan.H1 == "") {
+				firstChild = name
+			}
+			if !voidElements[name] && (tokenType == html.StartTagToken || !inForeign()) {
+				open = append(open, element)
+			}
+
+		case html.EndTagToken:
+			if token.Data == "head" {
+				inHead = false
+			}
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].Data == token.Data {
+					closeFrom(i)
+					break
+				}
+			}
+		}
+	}
+}
+
+// reads the links and meta of a start tag
+func (scan *pageScan) read(element *scanElement) {
+	switch element.Data {
+	case "a":
+		for _, attr := range element.Attr {
+			if attr.Key == "href" {
+				scan.Links = append(scan.Links, attr.Val)
+			}
+		}
+
+	case "meta":
+		name := strings.ToLower(element.attr("name"))
+		content := element.attr("content")
+		if name == "robots" && strings.Contains(strings.ToLower(content), "noindex") {
+			scan.Indexable = false
+			scan.NoIndex = true
+		}
+		if name == "description" {
+			scan.MetaDescription = content
+		}
+
+	case "link":
+		if rel := strings.ToLower(element.attr("rel")); rel == "canonical" {
+			if href := element.attr("href"); href != "" {
+				scan.Canonical = href
+			}
+		}
+	}
+}
+
+// returns what a scanning plugin gathered from a page. Pages that weren't scanned with its visitor are scanned now.
+func scanned[V tokenVisitor](page *Page, plugin scanningPlugin) V {
+	if v, ok := page.visitors[plugin.Name()].(V); ok {
+		return v
+	}
+	v := plugin.newVisitor(page.URL)
+	scanPage(bytes.NewReader(page.Body), v)
+	return v.(V)
+}
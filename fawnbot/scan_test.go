@@ -0,0 +1,438 @@
+package fawnbot
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// returns the pages in testdata/
+func readFixtures(t testing.TB) map[string][]byte {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.html"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no fixtures in testdata: %v", err)
+	}
+	fixtures := make(map[string][]byte)
+	for _, path := range paths {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixtures[filepath.Base(path)] = body
+	}
+	return fixtures
+}
+
+func TestScanMatchesLegacyParse(t *testing.T) {
+	for name, body := range readFixtures(t) {
+		scan, err := scanPage(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if legacy := legacyParsePage(body); !reflect.DeepEqual(scan, legacy) {
+			t.Errorf("%s: parsers disagree:\n  scan:   %+v\n  legacy: %+v", name, scan, legacy)
+		}
+	}
+}
+
+// plugins read the same from scanPage's tokens as from walking the DOM
+func TestScanVisitorsMatchPlugins(t *testing.T) {
+	plugins := []scanningPlugin{structuredDataPlugin{}, socialPlugin{}, &hreflangPlugin{}, imagesPlugin{}, newResourcesPlugin(nil), &searchPlugin{}}
+	for name, body := range readFixtures(t) {
+		pageURL := "https://example.com/" + name
+		visitors := make(map[string]tokenVisitor)
+		var list []tokenVisitor
+		for _, plugin := range plugins {
+			visitors[plugin.Name()] = plugin.newVisitor(pageURL)
+			list = append(list, visitors[plugin.Name()])
+		}
+		if _, err := scanPage(bytes.NewReader(body), list...); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		doc, err := html.Parse(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := &Page{URL: pageURL, Body: body, isHTML: true, visitors: visitors}
+		unscanned := &Page{URL: pageURL, Body: body, isHTML: true}
+
+		if got, want := scanned[*structuredDataVisitor](page, plugins[0]).result(), legacyStructuredData(doc); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: structured data %+v, want %+v", name, got, want)
+		}
+		social := scanned[*socialVisitor](page, plugins[1])
+		if openGraph, twitter := legacySocialTags(doc); !reflect.DeepEqual(social.openGraph, openGraph) || !reflect.DeepEqual(social.twitter, twitter) {
+			t.Errorf("%s: social tags %v %v, want %v %v", name, social.openGraph, social.twitter, openGraph, twitter)
+		}
+		if got, want := scanned[*hreflangVisitor](page, plugins[2]).links, legacyHreflangTags(pageURL, doc); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: hreflang %+v, want %+v", name, got, want)
+		}
+		want := legacyImages(pageURL, doc)
+		if got := scanned[*imagesVisitor](page, plugins[3]).images; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: images %+v, want %+v", name, got, want)
+		}
+		if got := scanned[*imagesVisitor](unscanned, plugins[3]).images; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: images of an unscanned page %+v, want %+v", name, got, want)
+		}
+		if got, want := scanned[*resourcesVisitor](page, plugins[4]).resources, legacyResources(pageURL, doc); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: resources %+v, want %+v", name, got, want)
+		}
+		if got, want := scanned[*visibleTextVisitor](page, plugins[5]).text(), legacyVisibleText(doc); !bytes.Equal(got, want) {
+			t.Errorf("%s: visible text %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestStructuredDataProperties(t *testing.T) {
+	body := `<div itemscope itemtype="https://schema.org/Product">
+		<span itemprop="name">Fawn</span>
+		<div itemprop="offers" itemscope itemtype="https://schema.org/Offer"><span itemprop="price">1</span></div>
+	</div>
+	<div typeof="schema:Organization"><span property="schema:name">Wild</span></div>`
+	items := parseStructuredData([]byte(body))
+	want := []StructuredItem{
+		{Format: formatMicrodata, Type: "Product"},
+		{Format: formatRDFa, Type: "Organization", Problems: []string{"missing url"}},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("got %+v, want %+v", items, want)
+	}
+}
+
+func BenchmarkScanPage(b *testing.B) {
+	for name, body := range readFixtures(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				scanPage(bytes.NewReader(body))
+			}
+		})
+	}
+}
+
+func BenchmarkLegacyParse(b *testing.B) {
+	for name, body := range readFixtures(b) {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				legacyParsePage(body)
+			}
+		})
+	}
+}
+
+// - - -
+
+// parses a page the way the crawler did before scanPage: html.Parse and a walk for meta, then a tokenizer pass over a string copy for links
+func legacyParsePage(body []byte) pageScan {
+	var scan pageScan
+	if doc, err := html.Parse(bytes.NewReader(body)); err == nil {
+		scan.Indexable, scan.NoIndex, scan.Canonical, scan.MetaDescription, scan.MetaTitle, scan.H1 = legacyParseHTML(doc)
+	}
+	scan.Links = legacyExtractLinks(string(body))
+	return scan
+}
+
+func legacyParseHTML(doc *html.Node) (bool, bool, string, string, string, string) {
+	indexable := true
+	noIndex := false
+	canonical := ""
+	metaDescription := ""
+	metaTitle := ""
+	h1 := ""
+
+	// recursive function to parse html
+	var traverseHTML func(*html.Node)
+	traverseHTML = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				var name, content string
+				for _, attr := range n.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "name":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+
+				if name == "robots" && strings.Contains(strings.ToLower(content), "noindex") {
+					indexable = false
+					noIndex = true
+				}
+				if name == "description" {
+					metaDescription = content
+				}
+
+			case "link":
+				var rel, href string
+				for _, attr := range n.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "rel":
+						rel = strings.ToLower(attr.Val)
+					case "href":
+						href = attr.Val
+					}
+				}
+				if rel == "canonical" && href != "" {
+					canonical = href
+				}
+
+			case "title":
+				if metaTitle == "" && n.FirstChild != nil {
+					metaTitle = n.FirstChild.Data
+				}
+
+			case "h1":
+				if h1 == "" && n.FirstChild != nil {
+					h1 = n.FirstChild.Data
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			traverseHTML(c)
+		}
+	}
+
+	traverseHTML(doc)
+	return indexable, noIndex, canonical, metaDescription, metaTitle, h1
+}
+
+// returns a list of hrefs from an html string
+func legacyExtractLinks(htmlString string) []string {
+	var links []string
+	tokenizer := html.NewTokenizer(strings.NewReader(htmlString))
+
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			if token.Data == "a" {
+				for _, attr := range token.Attr {
+					if attr.Key == "href" {
+						links = append(links, attr.Val)
+					}
+				}
+			}
+		}
+	}
+}
+
+// calls visit for every node under n, in document order
+func legacyWalk(n *html.Node, visit func(*html.Node)) {
+	visit(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		legacyWalk(c, visit)
+	}
+}
+
+// the plugins' DOM walks from before they read scanPage's tokens
+
+func legacyStructuredData(doc *html.Node) []StructuredItem {
+	var items []StructuredItem
+	properties := make(map[*html.Node]map[string]bool)
+	itemIndex := make(map[*html.Node]int)
+	startItem := func(n *html.Node, format, itemType string) {
+		itemIndex[n] = len(items)
+		properties[n] = make(map[string]bool)
+		items = append(items, StructuredItem{Format: format, Type: schemaType(itemType)})
+	}
+	addProperties := func(n *html.Node, propertyAttr, scopeAttr string) {
+		if !hasAttr(n, propertyAttr) {
+			return
+		}
+		for item := n.Parent; item != nil; item = item.Parent {
+			if item.Type == html.ElementNode && hasAttr(item, scopeAttr) {
+				if properties, ok := properties[item]; ok {
+					for _, property := range strings.Fields(getAttr(n, propertyAttr)) {
+						properties[schemaType(property)] = true
+					}
+				}
+				return
+			}
+		}
+	}
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		addProperties(n, "itemprop", "itemscope")
+		addProperties(n, "property", "typeof")
+		switch {
+		case n.Data == "script" && strings.EqualFold(strings.TrimSpace(getAttr(n, "type")), "application/ld+json"):
+			items = append(items, parseJSONLD(nodeText(n))...)
+		case hasAttr(n, "itemscope") && !hasAttr(n, "itemprop"):
+			startItem(n, formatMicrodata, getAttr(n, "itemtype"))
+		case hasAttr(n, "typeof") && !hasAttr(n, "property"):
+			startItem(n, formatRDFa, getAttr(n, "typeof"))
+		}
+	})
+	for n, i := range itemIndex {
+		items[i] = newStructuredItem(items[i].Format, items[i].Type, properties[n])
+	}
+	return items
+}
+
+func legacySocialTags(doc *html.Node) (map[string]string, map[string]string) {
+	openGraph, twitter := make(map[string]string), make(map[string]string)
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode || n.Data != "meta" {
+			return
+		}
+		content := strings.TrimSpace(getAttr(n, "content"))
+		property := strings.ToLower(getAttr(n, "property"))
+		name := strings.ToLower(getAttr(n, "name"))
+		if _, ok := openGraph[property]; strings.HasPrefix(property, "og:") && !ok {
+			openGraph[property] = content
+		}
+		for _, key := range []string{name, property} {
+			if _, ok := twitter[key]; strings.HasPrefix(key, "twitter:") && !ok {
+				twitter[key] = content
+			}
+		}
+	})
+	return openGraph, twitter
+}
+
+func legacyHreflangTags(pageURL string, doc *html.Node) []HreflangLink {
+	var links []HreflangLink
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "link" && hasAttr(n, "hreflang") && hasRel(getAttr(n, "rel"), "alternate") {
+			links = append(links, HreflangLink{Lang: strings.TrimSpace(getAttr(n, "hreflang")), URL: resolveReference(pageURL, strings.TrimSpace(getAttr(n, "href"))), Source: hreflangFromHTML})
+		}
+	})
+	return links
+}
+
+func legacyImages(pageURL string, doc *html.Node) []PageImage {
+	var images []PageImage
+	seen := make(map[string]bool)
+	add := func(image PageImage) {
+		image.URL = strings.TrimSpace(image.URL)
+		if image.URL == "" || strings.HasPrefix(strings.ToLower(image.URL), "data:") {
+			return
+		}
+		image.URL = resolveReference(pageURL, image.URL)
+		if key := image.URL + "|" + image.Source; !seen[key] {
+			seen[key] = true
+			images = append(images, image)
+		}
+	}
+	altText := func(img *html.Node) (string, string) {
+		if !hasAttr(img, "alt") {
+			return "", altMissing
+		}
+		if alt := strings.TrimSpace(getAttr(img, "alt")); alt != "" {
+			return alt, altPresent
+		}
+		return "", altEmpty
+	}
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.Data {
+		case "img":
+			image := PageImage{Source: imageFromImg, Width: getAttr(n, "width"), Height: getAttr(n, "height")}
+			image.Alt, image.AltStatus = altText(n)
+			image.URL = getAttr(n, "src")
+			add(image)
+			for _, candidate := range parseSrcset(getAttr(n, "srcset")) {
+				image.URL, image.Source = candidate, imageFromSrcset
+				add(image)
+			}
+		case "source":
+			if n.Parent != nil && n.Parent.Data == "picture" {
+				image := PageImage{Source: imageFromPicture}
+				for img := n.Parent.FirstChild; img != nil; img = img.NextSibling {
+					if img.Type == html.ElementNode && img.Data == "img" {
+						image.Alt, image.AltStatus = altText(img)
+						break
+					}
+				}
+				for _, candidate := range parseSrcset(getAttr(n, "srcset")) {
+					image.URL = candidate
+					add(image)
+				}
+			}
+		case "style":
+			for _, match := range cssBackgroundImage.FindAllStringSubmatch(nodeText(n), -1) {
+				add(PageImage{URL: match[1], Source: imageFromCSS})
+			}
+		}
+		for _, match := range cssBackgroundImage.FindAllStringSubmatch(getAttr(n, "style"), -1) {
+			add(PageImage{URL: match[1], Source: imageFromCSS})
+		}
+	})
+	return images
+}
+
+func legacyResources(pageURL string, doc *html.Node) []PageResource {
+	var resources []PageResource
+	seen := make(map[string]bool)
+	add := func(ref, kind string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || strings.HasPrefix(strings.ToLower(ref), "data:") {
+			return
+		}
+		resource := PageResource{URL: resolveReference(pageURL, ref), Kind: kind}
+		if !seen[resource.URL] {
+			seen[resource.URL] = true
+			resources = append(resources, resource)
+		}
+	}
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.Data {
+		case "link":
+			rel := getAttr(n, "rel")
+			switch {
+			case hasRel(rel, "stylesheet"):
+				add(getAttr(n, "href"), resourceStylesheet)
+			case hasRel(rel, "preload") || hasRel(rel, "modulepreload"):
+				add(getAttr(n, "href"), preloadKind(getAttr(n, "as"), hasRel(rel, "modulepreload")))
+			}
+		case "script":
+			add(getAttr(n, "src"), resourceScript)
+		case "style":
+			for _, font := range fontURLs(nodeText(n)) {
+				add(font, resourceFont)
+			}
+		}
+	})
+	return resources
+}
+
+func legacyVisibleText(doc *html.Node) []byte {
+	var b strings.Builder
+	legacyWalk(doc, func(n *html.Node) {
+		if n.Type != html.TextNode {
+			return
+		}
+		for parent := n.Parent; parent != nil; parent = parent.Parent {
+			if parent.Type == html.ElementNode {
+				switch parent.Data {
+				case "script", "style", "noscript", "template", "head":
+					return
+				}
+			}
+		}
+		b.WriteString(n.Data)
+		b.WriteByte(' ')
+	})
+	return []byte(strings.Join(strings.Fields(b.String()), " "))
+}
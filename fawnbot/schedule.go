@@ -0,0 +1,447 @@
+package fawnbot
+
+/*
+| - - schedule.go - -
+| Decides when sites are due, from their crawl frequency (daily, weekly, fortnightly,
+| monthly or a cron expression), timezone and run history.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var crawlFrequencies = []string{"daily", "weekly", "fortnightly", "monthly", "a cron expression (e.g. '0 3 * * 1')"}
+
+type schedule interface {
+	// returns the first scheduled time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// Reports whether a site is due, i.e. a scheduled crawl has fallen between its last successful run and now.
+// Without a last run, only crawls scheduled since the start of today count, so new sites aren't crawled for every missed cycle.
+func IsSiteDue(s CrawlConfig, lastRun time.Time) (bool, error) {
+	return isSiteDueAt(s, lastRun, time.Now())
+}
+
+func isSiteDueAt(s CrawlConfig, lastRun time.Time, now time.Time) (bool, error) {
+	sched, location, err := parseSchedule(s)
+	if err != nil {
+		return false, err
+	}
+
+	now = now.In(location)
+	from := lastRun.In(location)
+	if lastRun.IsZero() {
+		from = midnight(now).Add(-time.Nanosecond)
+	}
+
+	next := sched.Next(from)
+	return !next.IsZero() && !next.After(now), nil
+}
+
+// Returns the next time a site is scheduled to crawl after t
+func NextCrawlTime(s CrawlConfig, t time.Time) (time.Time, error) {
+	sched, location, err := parseSchedule(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(t.In(location)), nil
+}
+
+func parseSchedule(s CrawlConfig) (schedule, *time.Location, error) {
+	location := time.UTC
+	if s.Timezone != "" {
+		loaded, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid timezone '%s': %v", s.Timezone, err)
+		}
+		location = loaded
+	}
+
+	var start time.Time
+	if s.CrawlStart != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", s.CrawlStart, location)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid date format: %v", err)
+		}
+		start = parsed
+	}
+
+	frequency := strings.ToLower(strings.TrimSpace(s.CrawlFrequency))
+	switch frequency {
+	case "daily", "weekly", "fortnightly", "monthly":
+		if start.IsZero() {
+			return nil, nil, fmt.Errorf("a CrawlStart date is required for %s crawls", frequency)
+		}
+	}
+
+	switch frequency {
+	case "daily":
+		return intervalSchedule{start: start, days: 1}, location, nil
+	case "weekly":
+		return intervalSchedule{start: start, days: 7}, location, nil
+	case "fortnightly":
+		return intervalSchedule{start: start, days: 14}, location, nil
+	case "monthly":
+		return monthlySchedule{start: start}, location, nil
+	}
+
+	cron, err := parseCron(frequency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid crawl frequency: '%s'. Expected one of: %s (%v)", s.CrawlFrequency, strings.Join(crawlFrequencies, ", "), err)
+	}
+	cron.start = start
+	return cron, location, nil
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func daysInMonth(year int, month time.Month, location *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, location).Day()
+}
+
+// - - - calendar schedules
+
+// runs at midnight every n days from the start date
+type intervalSchedule struct {
+	start time.Time
+	days  int
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.start) {
+		return s.start
+	}
+
+	// count calendar days in UTC so DST changes don't skew the count
+	day := midnight(t)
+	startUTC := time.Date(s.start.Year(), s.start.Month(), s.start.Day(), 0, 0, 0, 0, time.UTC)
+	dayUTC := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	daysPassed := int(dayUTC.Sub(startUTC).Hours() / 24)
+
+	offset := daysPassed - daysPassed%s.days
+	next := time.Date(s.start.Year(), s.start.Month(), s.start.Day()+offset, 0, 0, 0, 0, s.start.Location())
+	if !next.After(t) {
+		next = time.Date(s.start.Year(), s.start.Month(), s.start.Day()+offset+s.days, 0, 0, 0, 0, s.start.Location())
+	}
+	return next
+}
+
+// runs at midnight on the start date's day of the month, or the last day of shorter months
+type monthlySchedule struct {
+	start time.Time
+}
+
+func (s monthlySchedule) Next(t time.Time) time.Time {
+	if t.Before(s.start) {
+		return s.start
+	}
+
+	for i := 0; i <= 1; i++ {
+		year, month := t.Year(), t.Month()+time.Month(i)
+		day := min(s.start.Day(), daysInMonth(year, month, t.Location()))
+		if next := time.Date(year, month, day, 0, 0, 0, 0, t.Location()); next.After(t) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// - - - cron schedules
+
+// a standard five-field cron expression: minute hour day-of-month month day-of-week.
+// Supports *, lists, ranges, steps, month and weekday names, 'L' for the last day of the month, and @hourly/@daily/@weekly/@monthly.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	lastDay                                bool // day-of-month includes the last day of the month
+	anyDay, anyWeekday                     bool
+	start                                  time.Time
+}
+
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+var monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseCron(expression string) (cronSchedule, error) {
+	if macro, ok := cronMacros[expression]; ok {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("expected 5 fields, found %d", len(fields))
+	}
+
+	var cron cronSchedule
+	var err error
+	if cron.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return cronSchedule{}, fmt.Errorf("minute: %v", err)
+	}
+	if cron.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return cronSchedule{}, fmt.Errorf("hour: %v", err)
+	}
+
+	dayField := fields[2]
+	for _, part := range strings.Split(dayField, ",") {
+		if part == "l" {
+			cron.lastDay = true
+		}
+	}
+	dayField = strings.Join(removeString(strings.Split(dayField, ","), "l"), ",")
+	if dayField != "" {
+		if cron.days, err = parseCronField(dayField, 1, 31, nil); err != nil {
+			return cronSchedule{}, fmt.Errorf("day of month: %v", err)
+		}
+	}
+
+	if cron.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return cronSchedule{}, fmt.Errorf("month: %v", err)
+	}
+	if cron.weekdays, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return cronSchedule{}, fmt.Errorf("day of week: %v", err)
+	}
+	if cron.weekdays&(1<<7) != 0 {
+		cron.weekdays |= 1 // 7 is also Sunday
+	}
+
+	cron.anyDay = fields[2] == "*" || fields[2] == "?"
+	cron.anyWeekday = fields[4] == "*" || fields[4] == "?"
+
+	return cron, nil
+}
+
+// parses one cron field into a bitset of allowed values. Names (if given) are numbered from lowest upwards.
+func parseCronField(field string, lowest, highest int, names []string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			parsed, err := strconv.Atoi(stepPart)
+			if err != nil || parsed <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+			part, step = rangePart, parsed
+		}
+
+		low, high := lowest, highest
+		if part != "*" && part != "?" {
+			lowPart, highPart, isRange := strings.Cut(part, "-")
+			var err error
+			if low, err = parseCronValue(lowPart, lowest, names); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseCronValue(highPart, lowest, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				high = highest // e.g. 5/15 means every 15 from 5
+			}
+		}
+
+		if low < lowest || high > highest || low > high {
+			return 0, fmt.Errorf("'%s' is outside %d-%d", part, lowest, highest)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, lowest int, names []string) (int, error) {
+	for i, name := range names {
+		if value == name {
+			return i + lowest, nil
+		}
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", value)
+	}
+	return parsed, nil
+}
+
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	dayMatch := c.days&(1<<t.Day()) != 0 || (c.lastDay && t.Day() == daysInMonth(t.Year(), t.Month(), t.Location()))
+	weekdayMatch := c.weekdays&(1<<int(t.Weekday())) != 0
+
+	// as in standard cron, when both day fields are restricted either may match
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekdayMatch
+	case c.anyWeekday:
+		return dayMatch
+	default:
+		return dayMatch || weekdayMatch
+	}
+}
+
+func (c cronSchedule) Next(t time.Time) time.Time {
+	if t.Before(c.start) {
+		t = c.start.Add(-time.Minute)
+	}
+
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+
+	for next.Before(limit) {
+		switch {
+		case c.months&(1<<int(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !c.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case c.hours&(1<<next.Hour()) == 0:
+			next = nextLocalHour(next)
+		case c.minutes&(1<<next.Minute()) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+
+	return time.Time{} // e.g. 30 February
+}
+
+// returns the start of the hour after t's in local time. Truncating in absolute time would break zones with
+// half-hour offsets, e.g. India. An hour skipped when clocks go forward can normalise back to t's hour, so it's skipped.
+func nextLocalHour(t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+2, 0, 0, 0, t.Location())
+	}
+	return next
+}
+
+func removeString(values []string, remove string) []string {
+	var kept []string
+	for _, value := range values {
+		if value != remove {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// - - - run history
+
+type RunRecord struct {
+	LastSuccess time.Time `json:"LastSuccess"`
+	LastAttempt time.Time `json:"LastAttempt"`
+	LastError   string    `json:"LastError,omitempty"`
+	Failures    int       `json:"Failures,omitempty"` // consecutive failed runs
+}
+
+// A failed site is retried after retryBackoffBase, doubling with each consecutive failure up to retryBackoffMax
+const (
+	retryBackoffBase = 15 * time.Minute
+	retryBackoffMax  = 24 * time.Hour
+)
+
+// returns the earliest time a site may be retried after its last run failed, or the zero time if it didn't
+func (r RunRecord) RetryAt() time.Time {
+	if r.Failures == 0 {
+		return time.Time{}
+	}
+	backoff := retryBackoffBase
+	for i := 1; i < r.Failures && backoff < retryBackoffMax; i++ {
+		backoff *= 2
+	}
+	return r.LastAttempt.Add(min(backoff, retryBackoffMax))
+}
+
+// Stores when each site last ran, so missed schedules are caught up on the next run. Safe for concurrent use.
+type RunHistory struct {
+	mu   sync.Mutex
+	path string
+	Runs map[string]RunRecord `json:"Runs"`
+}
+
+// Loads run history from a JSON file. A missing file gives an empty history.
+func LoadRunHistory(path string) (*RunHistory, error) {
+	history := &RunHistory{path: path, Runs: make(map[string]RunRecord)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return history, nil
+	}
+	if err != nil {
+		return history, fmt.Errorf("failed to load run history: %v", err)
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return history, fmt.Errorf("failed to parse run history: %v", err)
+	}
+	if history.Runs == nil {
+		history.Runs = make(map[string]RunRecord)
+	}
+
+	return history, nil
+}
+
+func (h *RunHistory) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run history: %v", err)
+	}
+	if err := os.WriteFile(h.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save run history: %v", err)
+	}
+	return nil
+}
+
+func (h *RunHistory) LastSuccess(c CrawlConfig) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.Runs[c.RunKey()].LastSuccess
+}
+
+// Returns when a site whose last run failed may be retried, or the zero time if it didn't fail
+func (h *RunHistory) RetryAt(c CrawlConfig) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.Runs[c.RunKey()].RetryAt()
+}
+
+// Records an attempted run, which only counts towards the schedule if err is nil. Failures back off further retries.
+func (h *RunHistory) RecordRun(c CrawlConfig, at time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	record := h.Runs[c.RunKey()]
+	record.LastAttempt = at
+	record.LastError = ""
+	if err != nil {
+		record.LastError = err.Error()
+		record.Failures++
+	} else {
+		record.LastSuccess = at
+		record.Failures = 0
+	}
+	h.Runs[c.RunKey()] = record
+}
@@ -0,0 +1,143 @@
+package fawnbot
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s unavailable: %v", name, err)
+	}
+	return location
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		zone       string
+		expression string
+		from       string // local time
+		want       string // local time, or "" for none
+	}{
+		{"UTC", "0 11 * * *", "2025-06-01 09:00", "2025-06-01 11:00"},
+		{"UTC", "0 11 * * *", "2025-06-01 11:00", "2025-06-02 11:00"},
+		{"Asia/Kolkata", "0 11 * * *", "2025-06-01 09:00", "2025-06-01 11:00"},       // +05:30
+		{"Australia/Adelaide", "0 11 * * *", "2025-06-01 09:00", "2025-06-01 11:00"}, // +09:30
+		{"Asia/Kathmandu", "0 11 * * *", "2025-06-01 09:00", "2025-06-01 11:00"},     // +05:45
+		{"America/St_Johns", "15 3 * * *", "2025-06-01 09:00", "2025-06-02 03:15"},   // -02:30
+		{"Asia/Kolkata", "*/20 * * * *", "2025-06-01 09:59", "2025-06-01 10:00"},
+		{"Asia/Kolkata", "0 3 * * 1", "2025-06-01 09:00", "2025-06-02 03:00"},
+		{"UTC", "0 0 l * *", "2024-02-01 00:00", "2024-02-29 00:00"},
+		{"UTC", "0 0 30 2 *", "2025-01-01 00:00", ""},
+		{"UTC", "@hourly", "2025-06-01 09:59", "2025-06-01 10:00"},
+
+		// DST: 01:30 doesn't exist in London on 30 March 2025, so that day is skipped
+		{"Europe/London", "30 1 * * *", "2025-03-30 00:00", "2025-03-31 01:30"},
+		{"Europe/London", "30 2 * * *", "2025-03-30 00:00", "2025-03-30 02:30"},
+		{"Europe/London", "0 11 * * *", "2025-03-30 09:00", "2025-03-30 11:00"},
+		{"Europe/London", "0 11 * * *", "2025-10-26 09:00", "2025-10-26 11:00"},
+		{"Australia/Adelaide", "0 11 * * *", "2025-04-06 01:00", "2025-04-06 11:00"}, // DST ends
+		{"Australia/Adelaide", "0 11 * * *", "2025-10-05 01:00", "2025-10-05 11:00"}, // DST starts
+		{"America/New_York", "0 11 * * *", "2025-03-09 00:00", "2025-03-09 11:00"},
+	}
+
+	for _, test := range tests {
+		location := mustLoadLocation(t, test.zone)
+		cron, err := parseCron(test.expression)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", test.expression, err)
+		}
+		from, _ := time.ParseInLocation("2006-01-02 15:04", test.from, location)
+
+		got := cron.Next(from)
+		if test.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%s %q after %s: got %v, want none", test.zone, test.expression, test.from, got)
+			}
+			continue
+		}
+		want, _ := time.ParseInLocation("2006-01-02 15:04", test.want, location)
+		if !got.Equal(want) {
+			t.Errorf("%s %q after %s: got %v, want %v", test.zone, test.expression, test.from, got, want)
+		}
+	}
+}
+
+// the repeated hour when clocks go back only runs once
+func TestCronNextFallBack(t *testing.T) {
+	location := mustLoadLocation(t, "Europe/London")
+	cron, _ := parseCron("30 1 * * *")
+	first := cron.Next(time.Date(2025, 10, 26, 0, 0, 0, 0, location))
+	if first.Hour() != 1 || first.Minute() != 30 || first.Day() != 26 {
+		t.Fatalf("got %v, want 01:30 on 26 October", first)
+	}
+	if second := cron.Next(first); second.Day() != 27 {
+		t.Errorf("after %v: got %v, want 01:30 on 27 October", first, second)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "a * * * *"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("parseCron(%q): expected an error", expression)
+		}
+	}
+}
+
+func TestIsSiteDueAtHalfHourZone(t *testing.T) {
+	location := mustLoadLocation(t, "Asia/Kolkata")
+	site := CrawlConfig{CrawlFrequency: "0 11 * * *", Timezone: "Asia/Kolkata"}
+	lastRun := time.Date(2025, 6, 1, 11, 0, 0, 0, location)
+
+	tests := []struct {
+		now  time.Time
+		want bool
+	}{
+		{time.Date(2025, 6, 2, 10, 59, 0, 0, location), false},
+		{time.Date(2025, 6, 2, 11, 0, 0, 0, location), true},
+		{time.Date(2025, 6, 5, 8, 0, 0, 0, location), true}, // missed runs catch up
+	}
+	for _, test := range tests {
+		due, err := isSiteDueAt(site, lastRun, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if due != test.want {
+			t.Errorf("at %v: due = %v, want %v", test.now, due, test.want)
+		}
+	}
+}
+
+func TestRunHistoryRetryBackoff(t *testing.T) {
+	history, err := LoadRunHistory(filepath.Join(t.TempDir(), "runHistory.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := CrawlConfig{Root: "https://example.com"}
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if retryAt := history.RetryAt(site); !retryAt.IsZero() {
+		t.Fatalf("no runs: retry at %v, want zero", retryAt)
+	}
+	for failures, want := range []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour} {
+		history.RecordRun(site, at, errors.New("export failed"))
+		if got := history.RetryAt(site).Sub(at); got != want {
+			t.Errorf("%d failures: backoff %v, want %v", failures+1, got, want)
+		}
+	}
+	for range 20 {
+		history.RecordRun(site, at, errors.New("export failed"))
+	}
+	if got := history.RetryAt(site).Sub(at); got != retryBackoffMax {
+		t.Errorf("many failures: backoff %v, want %v", got, retryBackoffMax)
+	}
+
+	history.RecordRun(site, at, nil)
+	if retryAt := history.RetryAt(site); !retryAt.IsZero() {
+		t.Errorf("after a success: retry at %v, want zero", retryAt)
+	}
+}
@@ -0,0 +1,207 @@
+package fawnbot
+
+/*
+| - - scope.go - -
+| Crawl scope rules: which hosts, paths and query parameters a crawl follows, and a record of the URLs it didn't
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Which discovered URLs a site's crawl follows. Without one, a crawl stays on the root's host.
+type ScopeConfig struct {
+	Hosts                     []string `json:",omitempty"` // more hosts to crawl. "*.example.com" allows any subdomain of example.com
+	IncludeSubdomains         bool     `json:",omitempty"` // crawls every subdomain of the root's host
+	IncludePaths              []string `json:",omitempty"` // regexes. If any are set, a URL's path and query must match one
+	ExcludePaths              []string `json:",omitempty"` // regexes. URLs whose path and query match any aren't crawled, e.g. ^/wp-admin
+	ExcludeParams             []string `json:",omitempty"` // query parameters. URLs with any aren't crawled, e.g. sort
+	FollowOutOfScopeRedirects bool     `json:",omitempty"` // fetches out-of-scope redirect targets (though not their links)
+}
+
+// Why a URL is out of scope
+const (
+	outOfScopeHost     = "host"
+	outOfScopeIncluded = "path not included"
+	outOfScopeExcluded = "path excluded"
+	outOfScopeParam    = "query parameter"
+	outOfScopeRedirect = "redirect"
+	outOfScopeTrap     = "trap" // skipped as a likely crawler trap (see traps.go)
+)
+
+// A URL found during a crawl but not crawled, as it's out of scope
+type OutOfScopeURL struct {
+	Reason  string // host, path not included, path excluded, query parameter, or the crawler's own Scope's verdict
+	FoundOn string // the first page linking (or redirecting) to it
+	Inlinks int
+}
+
+// a compiled ScopeConfig. Scopes that explain their verdicts implement scopeReasoner.
+type configScope struct {
+	host          string   // host[:port], lowercased
+	hosts         []string // exact hosts
+	suffixes      []string // ".example.com", from wildcards and IncludeSubdomains
+	include       []*regexp.Regexp
+	exclude       []*regexp.Regexp
+	excludeParams []string
+}
+
+type scopeReasoner interface {
+	outOfScopeReason(rawURL string) string // "" if in scope
+}
+
+// compiles a scope for a crawl of root. A nil config keeps to the root's host.
+func (s *ScopeConfig) compile(root string) (*configScope, error) {
+	scope := &configScope{host: strings.ToLower(extractHost(root))}
+	if s == nil {
+		return scope, nil
+	}
+
+	if s.IncludeSubdomains {
+		scope.suffixes = append(scope.suffixes, "."+hostname(scope.host))
+	}
+	for _, host := range s.Hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			scope.suffixes = append(scope.suffixes, "."+suffix)
+		} else if host != "" {
+			scope.hosts = append(scope.hosts, host)
+		}
+	}
+
+	var problems []string
+	compileAll := func(field string, patterns []string) []*regexp.Regexp {
+		var compiled []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("invalid %s regex '%s': %v", field, pattern, err))
+				continue
+			}
+			compiled = append(compiled, re)
+		}
+		return compiled
+	}
+	scope.include = compileAll("IncludePaths", s.IncludePaths)
+	scope.exclude = compileAll("ExcludePaths", s.ExcludePaths)
+	scope.excludeParams = s.ExcludeParams
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return scope, nil
+}
+
+// the host without any port
+func hostname(host string) string {
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+func (s *configScope) InScope(rawURL string) bool {
+	return s.outOfScopeReason(rawURL) == ""
+}
+
+func (s *configScope) outOfScopeReason(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return outOfScopeHost
+	}
+
+	if !s.allowsHost(strings.ToLower(parsed.Host)) {
+		return outOfScopeHost
+	}
+
+	path := parsed.RequestURI()
+	if len(s.include) > 0 && !matchesAny(s.include, path) {
+		return outOfScopeIncluded
+	}
+	if matchesAny(s.exclude, path) {
+		return outOfScopeExcluded
+	}
+
+	query := parsed.Query()
+	for _, param := range s.excludeParams {
+		if query.Has(param) {
+			return outOfScopeParam
+		}
+	}
+
+	return ""
+}
+
+func (s *configScope) allowsHost(host string) bool {
+	if host == s.host {
+		return true
+	}
+	for _, allowed := range s.hosts {
+		if host == allowed {
+			return true
+		}
+	}
+	for _, suffix := range s.suffixes {
+		if strings.HasSuffix(hostname(host), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns why scope excludes a URL, or "" if it doesn't
+func outOfScopeReason(scope Scope, rawURL string) string {
+	if reasoner, ok := scope.(scopeReasoner); ok {
+		return reasoner.outOfScopeReason(rawURL)
+	}
+	if !scope.InScope(rawURL) {
+		return "out of scope"
+	}
+	return ""
+}
+
+// reports whether a link is an absolute web URL, so worth recording if it's out of scope (unlike mailto: links)
+func isWebURL(link string) bool {
+	lower := strings.ToLower(link)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// - - -
+
+// Writes every out-of-scope URL in a crawl as CSV, sorted by URL, reading them one at a time
+func WriteOutOfScopeCSV(w io.Writer, URLObjectList URLObjectList) error {
+	rows := newCSVRows(w)
+	if err := rows.write("URL", "Reason", "Found On", "Inlinks"); err != nil {
+		return err
+	}
+	err := URLObjectList.ForEachOutOfScope(func(url string, found *OutOfScopeURL) error {
+		return rows.write(url, found.Reason, found.FoundOn, found.Inlinks)
+	})
+	if err != nil {
+		return err
+	}
+	return rows.flush()
+}
+
+// Writes the crawl's out-of-scope URLs as CSV (see WriteOutOfScopeCSV)
+type OutOfScopeCSVExporter struct {
+	W io.Writer
+}
+
+func (e OutOfScopeCSVExporter) Export(ctx context.Context, result *CrawlResult) error {
+	return WriteOutOfScopeCSV(e.W, result.URLObjectList)
+}
@@ -0,0 +1,91 @@
+package fawnbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScopeOutOfScopeReason(t *testing.T) {
+	config := &ScopeConfig{
+		Hosts:         []string{"*.example.org", "Blog.Example.net"},
+		ExcludePaths:  []string{"^/wp-admin"},
+		ExcludeParams: []string{"sort"},
+	}
+	scope, err := config.compile("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/about", ""},
+		{"http://EXAMPLE.com/about", ""},
+		{"https://shop.example.org/", ""},
+		{"https://a.b.example.org/", ""},
+		{"https://blog.example.net/post", ""},
+		{"https://example.org/", outOfScopeHost}, // only its subdomains
+		{"https://www.example.net/", outOfScopeHost},
+		{"https://host.evil.com/", outOfScopeHost},
+		{"https://example.com.evil.com/", outOfScopeHost},
+		{"https://evilexample.org/", outOfScopeHost},
+		{"https://shop.example.org.evil.com/", outOfScopeHost},
+		{"https://example.com@host.evil.com/", outOfScopeHost}, // user info, not the host
+		{"https://example.com:8443/", outOfScopeHost},          // another port is another host
+		{"mailto:someone@example.com", outOfScopeHost},
+		{"https://example.com/wp-admin/edit.php", outOfScopeExcluded},
+		{"https://example.com/blog/wp-admin", ""},
+		{"https://example.com/shop?sort=price", outOfScopeParam},
+		{"https://example.com/shop?resort=1", ""},
+	}
+	for _, test := range tests {
+		if got := scope.outOfScopeReason(test.url); got != test.want {
+			t.Errorf("outOfScopeReason(%s) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestScopeIncludeSubdomains(t *testing.T) {
+	scope, err := (&ScopeConfig{IncludeSubdomains: true, IncludePaths: []string{"^/blog/"}}).compile("https://example.com:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com:8080/blog/post", ""},
+		{"https://news.example.com/blog/post", ""},
+		{"https://news.example.com:8080/blog/post", ""},
+		{"https://host.evil.com/blog/post", outOfScopeHost},
+		{"https://notexample.com/blog/post", outOfScopeHost},
+		{"https://news.example.com/shop", outOfScopeIncluded},
+	}
+	for _, test := range tests {
+		if got := scope.outOfScopeReason(test.url); got != test.want {
+			t.Errorf("outOfScopeReason(%s) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+// a nil config keeps to the root's host, and invalid regexes are all reported
+func TestScopeCompile(t *testing.T) {
+	scope, err := (*ScopeConfig)(nil).compile("https://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scope.InScope("https://example.com/a") || scope.InScope("https://sub.example.com/") || scope.InScope("https://host.evil.com/") {
+		t.Error("nil config: want only the root's host in scope")
+	}
+
+	_, err = (&ScopeConfig{IncludePaths: []string{"("}, ExcludePaths: []string{"["}}).compile("https://example.com/")
+	if err == nil {
+		t.Fatal("invalid regexes: expected an error")
+	}
+	for _, field := range []string{"IncludePaths", "ExcludePaths"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q doesn't mention %s", err, field)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package fawnbot
+
+/*
+| - - search.go - -
+| Custom search: finds pages containing, or missing, a piece of text or a regex
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// A SearchRule counts matches of a pattern on every page.
+// For example, pages missing a GTM container: {"Name": "GTM", "Pattern": "GTM-ABC123", "Missing": true}
+type SearchRule struct {
+	Name       string `json:"Name"`
+	Pattern    string `json:"Pattern"`
+	Regex      bool   `json:"Regex,omitempty"` // treats Pattern as a regular expression, rather than plain text
+	In         string `json:"In,omitempty"`    // html (default) searches the raw HTML, text the page's visible text
+	IgnoreCase bool   `json:"IgnoreCase,omitempty"`
+	Missing    bool   `json:"Missing,omitempty"` // flags pages without a match, rather than pages with one
+}
+
+const (
+	searchHTML = "html"
+	searchText = "text"
+)
+
+// A search rule's result for one page
+type SearchMatch struct {
+	Count   int
+	Flagged bool // whether the page contains the pattern, or for Missing rules, doesn't
+}
+
+// a compiled search rule
+type search struct {
+	SearchRule
+	regex *regexp.Regexp
+}
+
+// compiles search rules, reporting every invalid one
+func compileSearchRules(rules []SearchRule) ([]search, error) {
+	var compiled []search
+	var problems []string
+	names := make(map[string]bool)
+
+	for i, rule := range rules {
+		rule.In = strings.ToLower(rule.In)
+		if rule.In == "" {
+			rule.In = searchHTML
+		}
+
+		switch {
+		case rule.Name == "":
+			problems = append(problems, fmt.Sprintf("search rule %d: missing Name", i+1))
+			continue
+		case names[rule.Name]:
+			problems = append(problems, fmt.Sprintf("search rule '%s': duplicate Name", rule.Name))
+			continue
+		case rule.Pattern == "":
+			problems = append(problems, fmt.Sprintf("search rule '%s': missing Pattern", rule.Name))
+			continue
+		case rule.In != searchHTML && rule.In != searchText:
+			problems = append(problems, fmt.Sprintf("search rule '%s': unknown In '%s': expected html or text", rule.Name, rule.In))
+			continue
+		}
+		names[rule.Name] = true
+
+		// plain text is searched as a quoted regex, so both kinds share the same matching
+		pattern := rule.Pattern
+		if !rule.Regex {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if rule.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("search rule '%s': %v", rule.Name, err))
+			continue
+		}
+
+		compiled = append(compiled, search{SearchRule: rule, regex: regex})
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return compiled, nil
+}
+
+func (s search) match(body []byte, text []byte) SearchMatch {
+	haystack := body
+	if s.In == searchText {
+		haystack = text
+	}
+	count := len(s.regex.FindAllIndex(haystack, -1))
+	return SearchMatch{Count: count, Flagged: (count > 0) != s.Missing}
+}
+
+// returns a page's visible text, leaving out the head, scripts, styles and other non-rendered elements
+func visibleText(body []byte) []byte {
+	v := &visibleTextVisitor{}
+	scanPage(bytes.NewReader(body), v)
+	return v.text()
+}
+
+type visibleTextVisitor struct {
+	b bytes.Buffer
+}
+
+func (v *visibleTextVisitor) visit(t *scanToken) {
+	if t.Type != html.TextToken || t.InHead {
+		return
+	}
+	for _, element := range t.Open {
+		switch element.Data {
+		case "script", "style", "noscript", "template":
+			return
+		}
+	}
+	v.b.WriteString(t.Data)
+	v.b.WriteByte(' ')
+}
+
+func (v *visibleTextVisitor) text() []byte {
+	return []byte(strings.Join(strings.Fields(v.b.String()), " "))
+}
+
+// - - -
+
+// searchPlugin runs a crawl config's search rules against every 200 page, storing results in URLObject.Search
+type searchPlugin struct {
+	rules    []SearchRule
+	searches []search
+}
+
+func (p *searchPlugin) Name() string { return "Search" }
+
+func (p *searchPlugin) newVisitor(string) tokenVisitor { return &visibleTextVisitor{} }
+
+// text rules search the visible text gathered in scanPage's pass, html rules the body
+func (p *searchPlugin) readsBody() bool {
+	for _, s := range p.searches {
+		if s.In != searchText {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *searchPlugin) readsDoc() bool { return false }
+
+func (p *searchPlugin) OnCrawlStart(ctx context.Context, root string) error {
+	searches, err := compileSearchRules(p.rules)
+	if err != nil {
+		return err
+	}
+	p.searches = searches
+	return nil
+}
+
+func (p *searchPlugin) OnPage(page *Page) error {
+	if page.Object.PageStatus != 200 {
+		return nil // error pages would otherwise all be "missing" every pattern
+	}
+
+	var text []byte
+	if page.isHTML {
+		text = scanned[*visibleTextVisitor](page, p).text()
+	}
+
+	page.Object.Search = make(map[string]SearchMatch)
+	for _, s := range p.searches {
+		page.Object.Search[s.Name] = s.match(page.Body, text)
+	}
+	return nil
+}
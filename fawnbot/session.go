@@ -0,0 +1,292 @@
+package fawnbot
+
+/*
+| - - session.go - -
+| Per-site request settings: custom headers, cookies and HTTP auth, sent with every request to the site's hosts
+*/
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extra settings for every request to a site's hosts (never to external links). Credentials aren't set here, as the
+// control sheet is shared: Auth only names a secret, read from the secrets file or the environment (see Secret).
+type RequestConfig struct {
+	Headers   map[string]string `json:",omitempty"` // e.g. a header that lets the crawler past a CDN's challenge
+	Cookies   map[string]string `json:",omitempty"` // sent with every request, unless the site sets its own value
+	CookieJar bool              `json:",omitempty"` // keeps cookies the site sets for the rest of the crawl. Always on with Login
+	Auth      *AuthConfig       `json:",omitempty"`
+	Login     *LoginConfig      `json:",omitempty"` // a login form to submit before crawling (see login.go)
+}
+
+type AuthConfig struct {
+	Type   string // basic or bearer
+	Secret string // names the credentials in the secrets file or environment
+}
+
+// Credentials for a site. The secrets file (ProgramConfig.SecretsFile) is a JSON object of them by name. Environment
+// variables take precedence: WILDFAWN_<NAME>_USERNAME, _PASSWORD, _TOKEN and _HOSTS (comma-separated), with the name
+// uppercased and anything but letters and digits replaced by _.
+type Secret struct {
+	Username string   `json:",omitempty"` // basic auth
+	Password string   `json:",omitempty"` // basic auth
+	Token    string   `json:",omitempty"` // bearer auth
+	Hosts    []string `json:",omitempty"` // the only hosts the credentials are sent to, e.g. example.com (www. is ignored). Required
+}
+
+// Checks a request config, returning every problem found
+func (r *RequestConfig) validate() []string {
+	if r == nil {
+		return nil
+	}
+	problems := r.Login.validate()
+	if r.Auth == nil {
+		return problems
+	}
+	if r.Auth.Type != "basic" && r.Auth.Type != "bearer" {
+		problems = append(problems, fmt.Sprintf("invalid Auth Type '%s': expected basic or bearer", r.Auth.Type))
+	}
+	if r.Auth.Secret == "" {
+		problems = append(problems, "missing Auth Secret")
+	}
+	return problems
+}
+
+// loads the named credentials from the environment or, failing that, the secrets file
+func loadSecret(filename, name string) (Secret, error) {
+	prefix := "WILDFAWN_" + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name)) + "_"
+	secret := Secret{Username: os.Getenv(prefix + "USERNAME"), Password: os.Getenv(prefix + "PASSWORD"), Token: os.Getenv(prefix + "TOKEN")}
+	if secret.Username != "" || secret.Password != "" || secret.Token != "" {
+		if hosts := os.Getenv(prefix + "HOSTS"); hosts != "" {
+			secret.Hosts = strings.Split(hosts, ",")
+		}
+		return secret, nil
+	}
+
+	if filename == "" {
+		return Secret{}, fmt.Errorf("no secret '%s' in the environment, and no secrets file is set", name)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Secret{}, fmt.Errorf("failed to load secrets file: %v", err)
+	}
+	var secrets map[string]Secret
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return Secret{}, fmt.Errorf("failed to parse secrets file: %v", err)
+	}
+	secret, ok := secrets[name]
+	if !ok {
+		return Secret{}, fmt.Errorf("no secret '%s' in the environment or %s", name, filename)
+	}
+	return secret, nil
+}
+
+// reports whether a secret's credentials may be sent to a URL's host. Hosts may be listed with or without a port.
+func (s Secret) allowsHost(u *url.URL) bool {
+	trim := func(host string) string { return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(host)), "www.") }
+	for _, host := range s.Hosts {
+		if host := trim(host); host != "" && (host == trim(u.Host) || host == trim(u.Hostname())) {
+			return true
+		}
+	}
+	return false
+}
+
+// loads the named credentials, refusing them if the root isn't on one of their hosts. The control sheet is shared, so
+// without this anyone editing it could point a site's credentials at a host of their own.
+func loadSiteSecret(filename, name, root string) (Secret, error) {
+	secret, err := loadSecret(filename, name)
+	if err != nil {
+		return Secret{}, err
+	}
+	rootURL, err := url.Parse(root)
+	if err != nil {
+		return Secret{}, fmt.Errorf("invalid root '%s': %v", root, err)
+	}
+	if len(secret.Hosts) == 0 {
+		return Secret{}, fmt.Errorf("secret '%s' has no Hosts: list the hosts it may be sent to", name)
+	}
+	if !secret.allowsHost(rootURL) {
+		return Secret{}, fmt.Errorf("secret '%s' isn't allowed for %s: its Hosts are %s", name, rootURL.Host, strings.Join(secret.Hosts, ", "))
+	}
+	return secret, nil
+}
+
+// returns the Authorization header for an auth config and its credentials
+func authorization(auth *AuthConfig, secret Secret) (string, error) {
+	switch auth.Type {
+	case "basic":
+		if secret.Username == "" {
+			return "", fmt.Errorf("secret '%s' has no Username for basic auth", auth.Secret)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(secret.Username+":"+secret.Password)), nil
+	case "bearer":
+		if secret.Token == "" {
+			return "", fmt.Errorf("secret '%s' has no Token for bearer auth", auth.Secret)
+		}
+		return "Bearer " + secret.Token, nil
+	}
+	return "", fmt.Errorf("invalid Auth Type '%s': expected basic or bearer", auth.Type)
+}
+
+// - - -
+
+// session adds a site's headers, cookies and credentials to requests to its hosts, and keeps its cookie jar.
+// It's the transport and jar of the crawler's client, and passes requests through untouched until started.
+// Safe for concurrent use.
+type session struct {
+	base http.RoundTripper
+	jar  http.CookieJar // the client's own jar, or one made for CookieJar. May be nil
+
+	mu            sync.RWMutex
+	root          string
+	scope         Scope // the site's hosts are those it doesn't treat as external
+	headers       http.Header
+	cookies       []*http.Cookie
+	authorization string
+	authSecret    Secret // whose Hosts the authorization is sent to
+
+	// form login. Set by start, before any requests
+	loginConfig *LoginConfig
+	loginURL    *url.URL
+	loginSecret Secret
+
+	loginMu       sync.Mutex // held while logging back in
+	loggedIn      time.Time  // when the last login succeeded
+	loginFailures int        // in a row
+}
+
+// returns a copy of client whose requests go through a new session
+func newSessionClient(client *http.Client) (*http.Client, *session) {
+	s := &session{base: client.Transport, jar: client.Jar}
+	if s.base == nil {
+		s.base = http.DefaultTransport
+	}
+	sessionClient := *client
+	sessionClient.Transport = s
+	sessionClient.Jar = s
+	return &sessionClient, s
+}
+
+// applies a site's request config, loading its credentials from secretsFile or the environment
+func (s *session) start(root string, requests *RequestConfig, secretsFile string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root = root
+	if requests == nil {
+		return nil
+	}
+
+	s.headers = make(http.Header)
+	for name, value := range requests.Headers {
+		s.headers.Set(name, value)
+	}
+	s.cookies = nil
+	for _, name := range sortedKeys(requests.Cookies) {
+		s.cookies = append(s.cookies, &http.Cookie{Name: name, Value: requests.Cookies[name]})
+	}
+	if (requests.CookieJar || requests.Login != nil) && s.jar == nil {
+		s.jar, _ = cookiejar.New(nil) // only fails with options
+	}
+
+	if requests.Auth != nil {
+		secret, err := loadSiteSecret(secretsFile, requests.Auth.Secret, root)
+		if err != nil {
+			return err
+		}
+		if s.authorization, err = authorization(requests.Auth, secret); err != nil {
+			return err
+		}
+		s.authSecret = secret
+	}
+
+	if requests.Login != nil {
+		loginURL, err := url.Parse(requests.Login.URL)
+		if err != nil {
+			return fmt.Errorf("invalid Login URL '%s': %v", requests.Login.URL, err)
+		}
+		s.loginConfig, s.loginURL = requests.Login, loginURL
+		if requests.Login.Secret != "" {
+			if s.loginSecret, err = loadSiteSecret(secretsFile, requests.Login.Secret, root); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sets the crawl's root (after www. normalisation) and scope, which decide the site's hosts
+func (s *session) setScope(root string, scope Scope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root, s.scope = root, scope
+}
+
+// reports whether a URL is on one of the site's hosts. Until the scope is set, that's the root's host, with or without www.
+func (s *session) isSite(u *url.URL) bool {
+	if s.scope != nil {
+		return !isExternalLink(s.scope, s.root, u.String())
+	}
+	trim := func(host string) string { return strings.TrimPrefix(strings.ToLower(host), "www.") }
+	return trim(u.Host) == trim(extractHost(s.root))
+}
+
+func (s *session) RoundTrip(request *http.Request) (*http.Response, error) {
+	s.mu.RLock()
+	site := s.isSite(request.URL)
+	headers, cookies, authorization := s.headers, s.cookies, s.authorization
+	if !s.authSecret.allowsHost(request.URL) {
+		authorization = "" // e.g. a host the scope allows, but the secret doesn't
+	}
+	s.mu.RUnlock()
+	if !site || (len(headers) == 0 && len(cookies) == 0 && authorization == "") {
+		return s.base.RoundTrip(request)
+	}
+
+	request = request.Clone(request.Context())
+	for name, values := range headers {
+		request.Header[name] = values
+	}
+	for _, cookie := range cookies {
+		if _, err := request.Cookie(cookie.Name); err != nil { // the jar's cookies, which the site set, win
+			request.AddCookie(cookie)
+		}
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	return s.base.RoundTrip(request)
+}
+
+func (s *session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.mu.RLock()
+	jar := s.jar
+	s.mu.RUnlock()
+	if jar != nil {
+		jar.SetCookies(u, cookies)
+	}
+}
+
+func (s *session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.RLock()
+	jar := s.jar
+	s.mu.RUnlock()
+	if jar == nil {
+		return nil
+	}
+	return jar.Cookies(u)
+}
@@ -0,0 +1,72 @@
+package fawnbot
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretAllowsHost(t *testing.T) {
+	secret := Secret{Hosts: []string{"example.com", "staging.example.com:8443"}}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/", true},
+		{"https://www.example.com/", true},
+		{"https://EXAMPLE.com:8080/", true},
+		{"https://staging.example.com:8443/", true},
+		{"https://staging.example.com/", false},
+		{"https://shop.example.com/", false},
+		{"https://example.com.evil.com/", false},
+		{"https://evil.com/?example.com", false},
+	}
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if got := secret.allowsHost(u); got != test.want {
+			t.Errorf("allowsHost(%s) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestLoadSiteSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	os.WriteFile(path, []byte(`{"bound": {"Token": "t", "Hosts": ["example.com"]}, "unbound": {"Token": "t"}}`), 0600)
+
+	if _, err := loadSiteSecret(path, "bound", "https://www.example.com/"); err != nil {
+		t.Errorf("bound secret on its host: %v", err)
+	}
+	if _, err := loadSiteSecret(path, "bound", "https://evil.com/"); err == nil {
+		t.Error("bound secret on another host: expected an error")
+	}
+	if _, err := loadSiteSecret(path, "unbound", "https://example.com/"); err == nil {
+		t.Error("secret without Hosts: expected an error")
+	}
+
+	t.Setenv("WILDFAWN_ENV_SECRET_TOKEN", "t")
+	t.Setenv("WILDFAWN_ENV_SECRET_HOSTS", "other.com,example.com")
+	if _, err := loadSiteSecret("", "env-secret", "https://example.com/"); err != nil {
+		t.Errorf("secret from the environment: %v", err)
+	}
+}
+
+func TestCheckLoginHost(t *testing.T) {
+	s := &session{root: "https://example.com/", loginConfig: &LoginConfig{}}
+	if err := s.checkLoginHost("https://www.example.com/login"); err != nil {
+		t.Errorf("login on the site: %v", err)
+	}
+	if err := s.checkLoginHost("https://evil.com/login"); err == nil {
+		t.Error("login on another site: expected an error")
+	}
+
+	// with a secret, only its hosts
+	s.loginConfig.Secret = "bound"
+	s.loginSecret = Secret{Hosts: []string{"login.example.com"}}
+	if err := s.checkLoginHost("https://login.example.com/session"); err != nil {
+		t.Errorf("login on the secret's host: %v", err)
+	}
+	if err := s.checkLoginHost("https://example.com/login"); err == nil {
+		t.Error("login off the secret's hosts: expected an error")
+	}
+}
@@ -0,0 +1,183 @@
+package fawnbot
+
+/*
+| - - sitemap.go - -
+| Fetches and parses XML sitemaps, following sitemap indexes
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const maxSitemapIndexDepth = 3
+
+type SitemapEntry struct {
+	Loc        string
+	LastMod    string
+	ChangeFreq string
+	Priority   string
+	Sitemap    string         // the sitemap the entry was listed in
+	Alternates []HreflangLink `json:",omitempty"` // xhtml:link hreflang annotations
+}
+
+type sitemapXML struct {
+	URLs []struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+		Links      []struct {
+			Rel      string `xml:"rel,attr"`
+			Hreflang string `xml:"hreflang,attr"`
+			Href     string `xml:"href,attr"`
+		} `xml:"link"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// Returns every URL in a site's sitemaps. target is either a sitemap URL, or a site URL whose
+// sitemaps are found from its robots.txt (falling back to /sitemap.xml). A nil client fetches with the default one.
+func FetchSitemaps(ctx context.Context, client *http.Client, logger Logger, target string) ([]SitemapEntry, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	sitemaps, err := discoverSitemaps(ctx, client, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SitemapEntry
+	var problems []string
+	seen := make(map[string]bool)
+	for _, sitemap := range sitemaps {
+		found, err := fetchSitemap(ctx, client, logger, sitemap, seen, 0)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		entries = append(entries, found...)
+	}
+
+	if len(entries) == 0 && len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		logger.Printf("[!] %s", problem)
+	}
+
+	return entries, nil
+}
+
+func discoverSitemaps(ctx context.Context, client *http.Client, target string) ([]string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid URL '%s'", target)
+	}
+
+	path := strings.ToLower(parsed.Path)
+	if strings.HasSuffix(path, ".xml") || strings.HasSuffix(path, ".xml.gz") {
+		return []string{target}, nil
+	}
+
+	robots, err := getRobots(ctx, client, target)
+	if err == nil && len(robots.Sitemaps) > 0 {
+		return robots.Sitemaps, nil
+	}
+
+	root, err := extractRootURL(target)
+	if err != nil {
+		return nil, err
+	}
+	return []string{root + "/sitemap.xml"}, nil
+}
+
+func fetchSitemap(ctx context.Context, client *http.Client, logger Logger, sitemapURL string, seen map[string]bool, depth int) ([]SitemapEntry, error) {
+	if seen[sitemapURL] {
+		return nil, nil
+	}
+	seen[sitemapURL] = true
+
+	body, status, err := fetchFollowingRedirects(ctx, client, sitemapURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sitemap %s: %v", sitemapURL, err)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("failed to fetch sitemap %s: status %d", sitemapURL, status)
+	}
+
+	data := []byte(body)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress sitemap %s: %v", sitemapURL, err)
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("failed to decompress sitemap %s: %v", sitemapURL, err)
+		}
+	}
+
+	var parsed sitemapXML
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+	}
+
+	var entries []SitemapEntry
+	for _, u := range parsed.URLs {
+		entry := SitemapEntry{Loc: strings.TrimSpace(u.Loc), LastMod: u.LastMod, ChangeFreq: u.ChangeFreq, Priority: u.Priority, Sitemap: sitemapURL}
+		for _, link := range u.Links {
+			if link.Hreflang != "" && hasRel(link.Rel, "alternate") {
+				entry.Alternates = append(entry.Alternates, HreflangLink{Lang: link.Hreflang, URL: strings.TrimSpace(link.Href), Source: hreflangFromSitemap})
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	// sitemap indexes list further sitemaps
+	for _, child := range parsed.Sitemaps {
+		if depth >= maxSitemapIndexDepth {
+			logger.Printf("[!] Ignoring sitemap %s: nested too deeply", child.Loc)
+			continue
+		}
+		found, err := fetchSitemap(ctx, client, logger, strings.TrimSpace(child.Loc), seen, depth+1)
+		if err != nil {
+			logger.Printf("[!] %v", err)
+		}
+		entries = append(entries, found...)
+	}
+
+	return entries, nil
+}
+
+// fetches a URL, following up to 5 redirects
+func fetchFollowingRedirects(ctx context.Context, client *http.Client, target string) (string, int, error) {
+	for i := 0; i <= 5; i++ {
+		body, status, redirectTo, err := fetchURL(ctx, client, target)
+		if err != nil || status < 300 || status >= 400 || redirectTo == "" {
+			return body, status, err
+		}
+		target = resolveReference(target, redirectTo)
+	}
+	return "", 0, fmt.Errorf("too many redirects")
+}
+
+// resolves a possibly-relative reference against the URL it was found on
+func resolveReference(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
@@ -0,0 +1,103 @@
+package fawnbot
+
+/*
+| - - social.go - -
+| Open Graph and Twitter Card metadata, and an audit of the tags social sharing previews need
+*/
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Problems flagged by the social audit. Missing also covers tags that are present but empty.
+const (
+	socialMissingOGTitle = "missing og:title"
+	socialMissingOGImage = "missing og:image"
+	socialMissingOGURL   = "missing og:url"
+	socialOGURLMismatch  = "og:url differs from canonical"
+	socialBrokenOGImage  = "broken og:image"
+)
+
+// returns a page's og:* (property=) and twitter:* (name= or property=) tags. Only the first of repeated tags is kept.
+func parseSocialTags(body []byte) (map[string]string, map[string]string) {
+	v := newSocialVisitor()
+	scanPage(bytes.NewReader(body), v)
+	return v.openGraph, v.twitter
+}
+
+type socialVisitor struct {
+	openGraph map[string]string
+	twitter   map[string]string
+}
+
+func newSocialVisitor() *socialVisitor {
+	return &socialVisitor{openGraph: make(map[string]string), twitter: make(map[string]string)}
+}
+
+func (v *socialVisitor) visit(t *scanToken) {
+	if (t.Type != html.StartTagToken && t.Type != html.SelfClosingTagToken) || t.Data != "meta" {
+		return
+	}
+	content := strings.TrimSpace(t.attr("content"))
+	property := strings.ToLower(t.attr("property"))
+	name := strings.ToLower(t.attr("name"))
+
+	if strings.HasPrefix(property, "og:") {
+		if _, ok := v.openGraph[property]; !ok {
+			v.openGraph[property] = content
+		}
+	}
+	for _, key := range []string{name, property} {
+		if strings.HasPrefix(key, "twitter:") {
+			if _, ok := v.twitter[key]; !ok {
+				v.twitter[key] = content
+			}
+		}
+	}
+}
+
+// compares og:url with the canonical, resolving both against the page's URL
+func sameURL(pageURL, a, b string) bool {
+	return strings.TrimSuffix(resolveReference(pageURL, a), "/") == strings.TrimSuffix(resolveReference(pageURL, b), "/")
+}
+
+// - - -
+
+// socialPlugin records every 200 page's social tags and audits them, checking og:image URLs with the crawl's link checker
+type socialPlugin struct {
+	checker *linkChecker
+}
+
+func (socialPlugin) Name() string { return "Social" }
+
+func (socialPlugin) newVisitor(string) tokenVisitor { return newSocialVisitor() }
+
+func (p socialPlugin) OnPage(page *Page) error {
+	if !page.isHTML {
+		return nil
+	}
+	obj := page.Object
+	tags := scanned[*socialVisitor](page, p)
+	obj.OpenGraph, obj.TwitterCard = tags.openGraph, tags.twitter
+
+	var problems []string
+	if obj.OpenGraph["og:title"] == "" {
+		problems = append(problems, socialMissingOGTitle)
+	}
+	if image := obj.OpenGraph["og:image"]; image == "" {
+		problems = append(problems, socialMissingOGImage)
+	} else if isBrokenStatus(p.checker.status(page.Response.Request.Context(), resolveReference(page.URL, image))) {
+		problems = append(problems, socialBrokenOGImage)
+	}
+	if ogURL := obj.OpenGraph["og:url"]; ogURL == "" {
+		problems = append(problems, socialMissingOGURL)
+	} else if obj.Canonical != "" && !sameURL(page.URL, ogURL, obj.Canonical) {
+		problems = append(problems, socialOGURLMismatch)
+	}
+	obj.SocialProblems = problems
+
+	return nil
+}
@@ -0,0 +1,753 @@
+package fawnbot
+
+/*
+| - - store.go - -
+| Where a crawl in progress keeps its frontier, seen URLs and results: in memory, or spilled to a bbolt database on disk
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// crawlStore holds the frontier (URLs queued to crawl), the seen-set (URLs ever queued) and the results of a crawl
+type crawlStore interface {
+	Push(entry QueueEntry) error
+	PushFront(entry QueueEntry) error // requeues an entry to be crawled next, e.g. one interrupted mid-fetch
+	Pop() (QueueEntry, bool, error)   // false if the frontier is empty
+	Queued() int
+
+	See(url string) (bool, error) // marks a URL as seen, returning false if it already was
+
+	Put(url string, obj *URLObject) error
+	Get(url string) (*URLObject, bool, error) // changes to the result must be Put back
+	Crawled() int
+	ForEach(each func(url string, obj *URLObject) error) error // every result in URL order, without loading them all
+
+	RecordOutOfScope(url, foundOn, reason string) error                        // records a URL that isn't crawled, or counts another inlink to it
+	ForEachOutOfScope(each func(url string, found *OutOfScopeURL) error) error // every out-of-scope URL in URL order, without loading them all
+
+	trapStore // the trap detector's counts
+
+	Flush() error // called after each page, so disk stores can write in batches
+}
+
+// - - -
+// the in-memory store is the crawl state itself, which is checkpointed as JSON
+
+func (s *crawlState) Push(entry QueueEntry) error {
+	s.URLQueue = append(s.URLQueue, entry)
+	return nil
+}
+
+func (s *crawlState) PushFront(entry QueueEntry) error {
+	s.URLQueue = append([]QueueEntry{entry}, s.URLQueue...)
+	return nil
+}
+
+func (s *crawlState) Pop() (QueueEntry, bool, error) {
+	if len(s.URLQueue) == 0 {
+		return QueueEntry{}, false, nil
+	}
+	entry := s.URLQueue[0]
+	s.URLQueue = s.URLQueue[1:]
+	return entry, true, nil
+}
+
+func (s *crawlState) Queued() int { return len(s.URLQueue) }
+
+func (s *crawlState) See(url string) (bool, error) {
+	if s.VisitedURLs[url] {
+		return false, nil
+	}
+	s.VisitedURLs[url] = true
+	return true, nil
+}
+
+func (s *crawlState) Put(url string, obj *URLObject) error {
+	s.URLObjects[url] = obj
+	return nil
+}
+
+func (s *crawlState) Get(url string) (*URLObject, bool, error) {
+	obj, ok := s.URLObjects[url]
+	return obj, ok, nil
+}
+
+func (s *crawlState) Crawled() int { return len(s.URLObjects) }
+
+func (s *crawlState) ForEach(each func(url string, obj *URLObject) error) error {
+	for _, url := range sortedURLs(s.URLObjects) {
+		if err := each(url, s.URLObjects[url]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *crawlState) RecordOutOfScope(url, foundOn, reason string) error {
+	if found, ok := s.OutOfScope[url]; ok {
+		found.Inlinks++
+		return nil
+	}
+	if s.OutOfScope == nil {
+		s.OutOfScope = make(map[string]*OutOfScopeURL) // checkpointed before out-of-scope URLs were recorded
+	}
+	s.OutOfScope[url] = &OutOfScopeURL{Reason: reason, FoundOn: foundOn, Inlinks: 1}
+	return nil
+}
+
+func (s *crawlState) ForEachOutOfScope(each func(url string, found *OutOfScopeURL) error) error {
+	for _, url := range sortedKeys(s.OutOfScope) {
+		if err := each(url, s.OutOfScope[url]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *crawlState) TrapCombinations(path string) (map[string]bool, error) {
+	if s.Traps == nil {
+		return nil, nil
+	}
+	return s.Traps.Combinations[path], nil
+}
+
+func (s *crawlState) AddTrapCombination(path, combination string) error {
+	if s.Traps == nil {
+		s.Traps = newTrapCounts() // checkpointed before trap counts were kept
+	}
+	if s.Traps.Combinations[path] == nil {
+		s.Traps.Combinations[path] = make(map[string]bool)
+	}
+	s.Traps.Combinations[path][combination] = true
+	return nil
+}
+
+func (s *crawlState) TrapSequence(key string) (*numericSequence, error) {
+	if s.Traps == nil {
+		return nil, nil
+	}
+	return s.Traps.Sequences[key], nil
+}
+
+func (s *crawlState) PutTrapSequence(key string, sequence *numericSequence) error {
+	if s.Traps == nil {
+		s.Traps = newTrapCounts()
+	}
+	s.Traps.Sequences[key] = sequence
+	return nil
+}
+
+func (s *crawlState) Flush() error { return nil }
+
+// - - -
+
+var (
+	bucketMeta     = []byte("meta")
+	bucketFrontier = []byte("frontier")
+	bucketSeen     = []byte("seen")
+	bucketResults  = []byte("results")
+	bucketSkipped  = []byte("outofscope")
+	bucketTraps    = []byte("traps")
+)
+
+// diskStore keeps a crawl's state in a bbolt database, so memory use doesn't grow with the site.
+// Writes for each page share one transaction, committed by Flush. The database is also the crawl's checkpoint.
+type diskStore struct {
+	mu         sync.Mutex
+	db         *bolt.DB
+	tx         *bolt.Tx     // the open write transaction, if any
+	seen       *bloomFilter // nil if disabled
+	head, tail uint64       // the frontier's keys run from head to tail-1
+	crawled    int
+	started    time.Time // when the crawl first started
+}
+
+// opens the store at path, resuming its crawl if it was of the same root, otherwise starting one from root.
+// bloomURLs sizes a Bloom filter in front of the seen-set. 0 for none.
+func openDiskStore(path, root string, bloomURLs int) (*diskStore, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to create store directory: %v", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{NoSync: true}) // Close syncs
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open store: %v", err)
+	}
+
+	s := &diskStore{db: db, head: math.MaxUint64 / 2, tail: math.MaxUint64 / 2}
+	if bloomURLs > 0 {
+		s.seen = newBloomFilter(bloomURLs, 0.01)
+	}
+
+	resumed := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		// a store left by a crawl of another root (e.g. before a www. redirect was added) is started again
+		if meta := tx.Bucket(bucketMeta); meta != nil && string(meta.Get([]byte("root"))) != root {
+			for _, name := range [][]byte{bucketMeta, bucketFrontier, bucketSeen, bucketResults, bucketSkipped, bucketTraps} {
+				if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+		}
+		for _, name := range [][]byte{bucketMeta, bucketFrontier, bucketSeen, bucketResults, bucketSkipped, bucketTraps} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(bucketMeta)
+		if head, tail := meta.Get([]byte("head")), meta.Get([]byte("tail")); head != nil && tail != nil {
+			resumed = true
+			s.head, s.tail = binary.BigEndian.Uint64(head), binary.BigEndian.Uint64(tail)
+		}
+		s.crawled = tx.Bucket(bucketResults).Stats().KeyN
+		s.started = time.Now()
+		if started := meta.Get([]byte("started")); resumed && started != nil {
+			if err := s.started.UnmarshalText(started); err != nil {
+				return err
+			}
+		}
+		if s.seen != nil {
+			if err := tx.Bucket(bucketSeen).ForEach(func(k, v []byte) error {
+				s.seen.add(k)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		started, err := s.started.MarshalText()
+		if err != nil {
+			return err
+		}
+		if err := meta.Put([]byte("started"), started); err != nil {
+			return err
+		}
+		return meta.Put([]byte("root"), []byte(root))
+	})
+	if err != nil {
+		db.Close()
+		return nil, false, fmt.Errorf("failed to open store: %v", err)
+	}
+
+	// a new crawl starts from the root, as in newCrawlState
+	if !resumed {
+		if _, err := s.See(root); err != nil {
+			s.Close()
+			return nil, false, err
+		}
+		if err := s.Push(QueueEntry{URL: root}); err != nil {
+			s.Close()
+			return nil, false, err
+		}
+		if err := s.Flush(); err != nil {
+			s.Close()
+			return nil, false, err
+		}
+	}
+
+	return s, resumed, nil
+}
+
+// returns the open write transaction, starting one if needed
+func (s *diskStore) writeTx() (*bolt.Tx, error) {
+	if s.tx == nil {
+		tx, err := s.db.Begin(true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write to store: %v", err)
+		}
+		s.tx = tx
+	}
+	return s.tx, nil
+}
+
+func queueKey(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func (s *diskStore) Push(entry QueueEntry) error {
+	return s.pushAt(entry, false)
+}
+
+func (s *diskStore) PushFront(entry QueueEntry) error {
+	return s.pushAt(entry, true)
+}
+
+func (s *diskStore) pushAt(entry QueueEntry, front bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode queue entry: %v", err)
+	}
+
+	key := s.tail
+	if front {
+		key = s.head - 1
+	}
+	if err := tx.Bucket(bucketFrontier).Put(queueKey(key), data); err != nil {
+		return fmt.Errorf("failed to queue %s: %v", entry.URL, err)
+	}
+	if front {
+		s.head--
+	} else {
+		s.tail++
+	}
+	return nil
+}
+
+func (s *diskStore) Pop() (QueueEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.head == s.tail {
+		return QueueEntry{}, false, nil
+	}
+	tx, err := s.writeTx()
+	if err != nil {
+		return QueueEntry{}, false, err
+	}
+
+	frontier := tx.Bucket(bucketFrontier)
+	key := queueKey(s.head)
+	var entry QueueEntry
+	if err := json.Unmarshal(frontier.Get(key), &entry); err != nil {
+		return QueueEntry{}, false, fmt.Errorf("failed to read queue entry: %v", err)
+	}
+	if err := frontier.Delete(key); err != nil {
+		return QueueEntry{}, false, fmt.Errorf("failed to dequeue %s: %v", entry.URL, err)
+	}
+	s.head++
+
+	return entry, true, nil
+}
+
+func (s *diskStore) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.tail - s.head)
+}
+
+func (s *diskStore) See(url string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return false, err
+	}
+	seen := tx.Bucket(bucketSeen)
+
+	// the Bloom filter saves a disk read for URLs it's never had
+	key := []byte(url)
+	if s.seen == nil || s.seen.has(key) {
+		if seen.Get(key) != nil {
+			return false, nil
+		}
+	}
+
+	if err := seen.Put(key, []byte{1}); err != nil {
+		return false, fmt.Errorf("failed to record %s: %v", url, err)
+	}
+	if s.seen != nil {
+		s.seen.add(key)
+	}
+	return true, nil
+}
+
+func (s *diskStore) Put(url string, obj *URLObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode result for %s: %v", url, err)
+	}
+
+	results := tx.Bucket(bucketResults)
+	isNew := results.Get([]byte(url)) == nil
+	if err := results.Put([]byte(url), data); err != nil {
+		return fmt.Errorf("failed to store result for %s: %v", url, err)
+	}
+	if isNew {
+		s.crawled++
+	}
+	return nil
+}
+
+func (s *diskStore) Get(url string) (*URLObject, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return nil, false, err
+	}
+	data := tx.Bucket(bucketResults).Get([]byte(url))
+	if data == nil {
+		return nil, false, nil
+	}
+
+	var obj URLObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, false, fmt.Errorf("failed to read result for %s: %v", url, err)
+	}
+	return &obj, true, nil
+}
+
+func (s *diskStore) Crawled() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.crawled
+}
+
+const resultBatch = 256 // results read from disk at a time by ForEach and ForEachOutOfScope
+
+// reads the results in batches, so only a batch is in memory at once and each can Get and Put results
+func (s *diskStore) ForEach(each func(url string, obj *URLObject) error) error {
+	return forEachBatched(s, bucketResults, "result for", each)
+}
+
+// reads a bucket of JSON values in key order, a batch at a time. what names a value in errors
+func forEachBatched[V any](s *diskStore, bucket []byte, what string, each func(key string, value *V) error) error {
+	var after []byte // the last key read
+	for {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+
+		var keys []string
+		var values []*V
+		err := s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(bucket).Cursor()
+			k, v := cursor.First()
+			if after != nil {
+				if k, v = cursor.Seek(after); bytes.Equal(k, after) {
+					k, v = cursor.Next()
+				}
+			}
+			for ; k != nil && len(keys) < resultBatch; k, v = cursor.Next() {
+				var value V
+				if err := json.Unmarshal(v, &value); err != nil {
+					return fmt.Errorf("failed to read %s %s: %v", what, k, err)
+				}
+				keys, values = append(keys, string(k)), append(values, &value)
+			}
+			return nil
+		})
+		if err != nil || len(keys) == 0 {
+			return err
+		}
+
+		for i, key := range keys {
+			if err := each(key, values[i]); err != nil {
+				return err
+			}
+		}
+		after = []byte(keys[len(keys)-1])
+	}
+}
+
+func (s *diskStore) RecordOutOfScope(url, foundOn, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return err
+	}
+	skipped := tx.Bucket(bucketSkipped)
+
+	found := OutOfScopeURL{Reason: reason, FoundOn: foundOn}
+	if data := skipped.Get([]byte(url)); data != nil {
+		if err := json.Unmarshal(data, &found); err != nil {
+			return fmt.Errorf("failed to read out-of-scope URL %s: %v", url, err)
+		}
+	}
+	found.Inlinks++
+
+	data, err := json.Marshal(found)
+	if err != nil {
+		return fmt.Errorf("failed to encode out-of-scope URL %s: %v", url, err)
+	}
+	if err := skipped.Put([]byte(url), data); err != nil {
+		return fmt.Errorf("failed to record out-of-scope URL %s: %v", url, err)
+	}
+	return nil
+}
+
+func (s *diskStore) ForEachOutOfScope(each func(url string, found *OutOfScopeURL) error) error {
+	return forEachBatched(s, bucketSkipped, "out-of-scope URL", each)
+}
+
+// trap counts are kept by path, for parameter combinations, and by path and parameter, for numeric sequences
+var (
+	trapCombinationsPrefix = []byte("combinations ")
+	trapSequencePrefix     = []byte("sequence ")
+)
+
+func (s *diskStore) TrapCombinations(path string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	combinations, err := s.trapCombinations(path)
+	if err != nil || combinations == nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(combinations))
+	for _, combination := range combinations {
+		set[combination] = true
+	}
+	return set, nil
+}
+
+// returns the combinations stored for a path, in the order they were added. Call with s.mu held
+func (s *diskStore) trapCombinations(path string) ([]string, error) {
+	tx, err := s.writeTx()
+	if err != nil {
+		return nil, err
+	}
+	data := tx.Bucket(bucketTraps).Get(append(bytes.Clone(trapCombinationsPrefix), path...))
+	if data == nil {
+		return nil, nil
+	}
+	var combinations []string
+	if err := json.Unmarshal(data, &combinations); err != nil {
+		return nil, fmt.Errorf("failed to read trap counts for %s: %v", path, err)
+	}
+	return combinations, nil
+}
+
+func (s *diskStore) AddTrapCombination(path, combination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	combinations, err := s.trapCombinations(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(append(combinations, combination))
+	if err != nil {
+		return fmt.Errorf("failed to encode trap counts for %s: %v", path, err)
+	}
+	if err := s.tx.Bucket(bucketTraps).Put(append(bytes.Clone(trapCombinationsPrefix), path...), data); err != nil {
+		return fmt.Errorf("failed to record trap counts for %s: %v", path, err)
+	}
+	return nil
+}
+
+func (s *diskStore) TrapSequence(key string) (*numericSequence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return nil, err
+	}
+	data := tx.Bucket(bucketTraps).Get(append(bytes.Clone(trapSequencePrefix), key...))
+	if data == nil {
+		return nil, nil
+	}
+	var sequence numericSequence
+	if err := json.Unmarshal(data, &sequence); err != nil {
+		return nil, fmt.Errorf("failed to read trap counts for %s: %v", key, err)
+	}
+	return &sequence, nil
+}
+
+func (s *diskStore) PutTrapSequence(key string, sequence *numericSequence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.writeTx()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(sequence)
+	if err != nil {
+		return fmt.Errorf("failed to encode trap counts for %s: %v", key, err)
+	}
+	if err := tx.Bucket(bucketTraps).Put(append(bytes.Clone(trapSequencePrefix), key...), data); err != nil {
+		return fmt.Errorf("failed to record trap counts for %s: %v", key, err)
+	}
+	return nil
+}
+
+// commits the page's writes, along with where the frontier starts and ends
+func (s *diskStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx = nil
+
+	meta := tx.Bucket(bucketMeta)
+	if err := meta.Put([]byte("head"), queueKey(s.head)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to write to store: %v", err)
+	}
+	if err := meta.Put([]byte("tail"), queueKey(s.tail)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to write to store: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write to store: %v", err)
+	}
+	return nil
+}
+
+// flushes the store and syncs it to disk, so it survives the machine stopping
+func (s *diskStore) Sync() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	if err := s.db.Sync(); err != nil {
+		return fmt.Errorf("failed to sync store: %v", err)
+	}
+	return nil
+}
+
+// syncs the store, then closes it
+func (s *diskStore) Close() error {
+	if err := s.Sync(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// - - -
+
+// bloomFilter answers "definitely not seen" or "maybe seen", in a fixed amount of memory
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// sizes a filter for n items with the given false positive rate
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (uint64(m)+63)/64), hashes: uint64(k)}
+}
+
+// the k bit positions for an item, by double hashing
+func (b *bloomFilter) positions(item []byte, each func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write(item)
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31 | 1
+	size := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.hashes; i++ {
+		each((h1 + i*h2) % size)
+	}
+}
+
+func (b *bloomFilter) add(item []byte) {
+	b.positions(item, func(bit uint64) { b.bits[bit/64] |= 1 << (bit % 64) })
+}
+
+func (b *bloomFilter) has(item []byte) bool {
+	found := true
+	b.positions(item, func(bit uint64) {
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// - - -
+// a finished crawl's results, read from its store
+
+// resultStore is where a finished crawl's results are read from
+type resultStore interface {
+	ForEach(each func(url string, obj *URLObject) error) error
+	ForEachOutOfScope(each func(url string, found *OutOfScopeURL) error) error
+	Get(url string) (*URLObject, bool, error)
+	Put(url string, obj *URLObject) error
+	Crawled() int
+}
+
+// Calls each for every URL's results in URL order, stopping at the first error. A finished crawl's results are read from
+// its store as they're needed, so changes to them are lost.
+func (u URLObjectList) ForEach(each func(url string, obj *URLObject) error) error {
+	if u.results != nil {
+		return u.results.ForEach(each)
+	}
+	for _, url := range sortedURLs(u.URLObjects) {
+		if err := each(url, u.URLObjects[url]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls each for every out-of-scope URL in URL order, stopping at the first error
+func (u URLObjectList) ForEachOutOfScope(each func(url string, found *OutOfScopeURL) error) error {
+	if u.results != nil {
+		return u.results.ForEachOutOfScope(each)
+	}
+	for _, url := range sortedKeys(u.OutOfScope) {
+		if err := each(url, u.OutOfScope[url]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a URL's results, if it was crawled
+func (u URLObjectList) Get(url string) (*URLObject, bool, error) {
+	if u.results != nil {
+		return u.results.Get(url)
+	}
+	obj, ok := u.URLObjects[url]
+	return obj, ok, nil
+}
+
+// The number of URLs crawled
+func (u URLObjectList) Len() int {
+	if u.results != nil {
+		return u.results.Crawled()
+	}
+	return len(u.URLObjects)
+}
+
+// saves changes to a URL's results
+func (u URLObjectList) put(url string, obj *URLObject) error {
+	if u.results != nil {
+		return u.results.Put(url, obj)
+	}
+	u.URLObjects[url] = obj
+	return nil
+}
+
+// Closes the crawl's store, once its results are no longer needed. Lists read from a disk store can't be read after.
+func (u URLObjectList) Close() error {
+	if u.close == nil {
+		return nil
+	}
+	return u.close()
+}
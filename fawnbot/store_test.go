@@ -0,0 +1,202 @@
+package fawnbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	filter := newBloomFilter(n, 0.01)
+	for i := 0; i < n; i++ {
+		filter.add([]byte(fmt.Sprintf("https://example.com/page/%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		if !filter.has([]byte(fmt.Sprintf("https://example.com/page/%d", i))) {
+			t.Fatalf("page %d: added but not found", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if filter.has([]byte(fmt.Sprintf("https://example.com/other/%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.03 {
+		t.Errorf("false positive rate %.3f, want about 0.01", rate)
+	}
+}
+
+func openTestDiskStore(t *testing.T, path, root string) (*diskStore, bool) {
+	t.Helper()
+	store, resumed, err := openDiskStore(path, root, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, resumed
+}
+
+func TestDiskStoreQueue(t *testing.T) {
+	store, resumed := openTestDiskStore(t, filepath.Join(t.TempDir(), "crawl.db"), "https://example.com/")
+	defer store.Close()
+	if resumed {
+		t.Fatal("new store reported as resumed")
+	}
+
+	// a new store starts with the root queued and seen
+	if isNew, _ := store.See("https://example.com/"); isNew {
+		t.Error("root not seen")
+	}
+	for _, url := range []string{"https://example.com/a", "https://example.com/b"} {
+		if err := store.Push(QueueEntry{URL: url, CrawlDepth: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.PushFront(QueueEntry{URL: "https://example.com/first"}); err != nil {
+		t.Fatal(err)
+	}
+	if store.Queued() != 4 {
+		t.Errorf("Queued() = %d, want 4", store.Queued())
+	}
+
+	want := []string{"https://example.com/first", "https://example.com/", "https://example.com/a", "https://example.com/b"}
+	for _, url := range want {
+		entry, ok, err := store.Pop()
+		if err != nil || !ok || entry.URL != url {
+			t.Fatalf("Pop() = %v, %v, %v, want %s", entry.URL, ok, err, url)
+		}
+	}
+	if _, ok, _ := store.Pop(); ok {
+		t.Error("Pop() on an empty frontier returned an entry")
+	}
+}
+
+func TestDiskStoreResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	store, _ := openTestDiskStore(t, path, "https://example.com/")
+	store.Pop() // the root
+	store.See("https://example.com/a")
+	store.Push(QueueEntry{URL: "https://example.com/a", CrawlDepth: 1})
+	store.Put("https://example.com/", &URLObject{PageStatus: 200, Outlinks: 1})
+	store.RecordOutOfScope("https://other.com/", "https://example.com/", "host")
+	started := store.started
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, resumed := openTestDiskStore(t, path, "https://example.com/")
+	defer store.Close()
+	if !resumed {
+		t.Fatal("store not resumed")
+	}
+	if !store.started.Equal(started) {
+		t.Errorf("started = %v, want %v", store.started, started)
+	}
+	if store.Queued() != 1 || store.Crawled() != 1 {
+		t.Errorf("Queued() = %d, Crawled() = %d, want 1 and 1", store.Queued(), store.Crawled())
+	}
+	if isNew, _ := store.See("https://example.com/a"); isNew {
+		t.Error("seen URL forgotten")
+	}
+	if entry, ok, _ := store.Pop(); !ok || entry.URL != "https://example.com/a" || entry.CrawlDepth != 1 {
+		t.Errorf("Pop() = %+v, %v", entry, ok)
+	}
+	if obj, ok, _ := store.Get("https://example.com/"); !ok || obj.PageStatus != 200 {
+		t.Errorf("Get() = %+v, %v", obj, ok)
+	}
+	var skipped []string
+	store.ForEachOutOfScope(func(url string, found *OutOfScopeURL) error {
+		skipped = append(skipped, url)
+		return nil
+	})
+	if len(skipped) != 1 || skipped[0] != "https://other.com/" {
+		t.Errorf("out-of-scope URLs = %q, want https://other.com/", skipped)
+	}
+}
+
+// a store left by a crawl of another root starts again
+func TestDiskStoreOtherRoot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	store, _ := openTestDiskStore(t, path, "https://example.com/")
+	store.Put("https://example.com/", &URLObject{PageStatus: 200})
+	store.Close()
+
+	store, resumed := openTestDiskStore(t, path, "https://www.example.com/")
+	defer store.Close()
+	if resumed || store.Crawled() != 0 || store.Queued() != 1 {
+		t.Errorf("resumed = %v, Crawled() = %d, Queued() = %d, want a new crawl", resumed, store.Crawled(), store.Queued())
+	}
+}
+
+// results are read in URL order across batches, and can be changed as they're read
+func TestDiskStoreForEach(t *testing.T) {
+	store, _ := openTestDiskStore(t, filepath.Join(t.TempDir(), "crawl.db"), "https://example.com/")
+	defer store.Close()
+	count := resultBatch*2 + 10
+	for i := count - 1; i >= 0; i-- {
+		store.Put(fmt.Sprintf("https://example.com/%04d", i), &URLObject{Inlinks: i})
+	}
+
+	list := URLObjectList{results: store}
+	seen := 0
+	err := list.ForEach(func(url string, obj *URLObject) error {
+		if want := fmt.Sprintf("https://example.com/%04d", seen); url != want || obj.Inlinks != seen {
+			t.Fatalf("result %d: got %s (%d inlinks), want %s", seen, url, obj.Inlinks, want)
+		}
+		seen++
+		obj.IsOrphan = true
+		return list.put(url, obj)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != count || list.Len() != count {
+		t.Errorf("read %d results, Len() = %d, want %d", seen, list.Len(), count)
+	}
+	if obj, _, _ := list.Get("https://example.com/0300"); !obj.IsOrphan {
+		t.Error("change made while reading wasn't saved")
+	}
+}
+
+// out-of-scope URLs are read in batches, and exported as if they'd been held in memory
+func TestDiskStoreForEachOutOfScope(t *testing.T) {
+	store, _ := openTestDiskStore(t, filepath.Join(t.TempDir(), "crawl.db"), "https://example.com/")
+	defer store.Close()
+	count := resultBatch + 10
+	inMemory := make(map[string]*OutOfScopeURL)
+	for i := count - 1; i >= 0; i-- {
+		url := fmt.Sprintf("https://other.com/%04d", i)
+		store.RecordOutOfScope(url, "https://example.com/", outOfScopeHost)
+		store.RecordOutOfScope(url, "https://example.com/a", outOfScopeHost)
+		inMemory[url] = &OutOfScopeURL{Reason: outOfScopeHost, FoundOn: "https://example.com/", Inlinks: 2}
+	}
+
+	list := URLObjectList{results: store}
+	seen := 0
+	err := list.ForEachOutOfScope(func(url string, found *OutOfScopeURL) error {
+		if want := fmt.Sprintf("https://other.com/%04d", seen); url != want || *found != *inMemory[want] {
+			t.Fatalf("URL %d: got %s %+v, want %s %+v", seen, url, found, want, inMemory[want])
+		}
+		seen++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != count {
+		t.Errorf("read %d out-of-scope URLs, want %d", seen, count)
+	}
+
+	var streamed bytes.Buffer
+	if err := WriteCrawlJSON(&streamed, list); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent(URLObjectList{URLObjects: map[string]*URLObject{}, OutOfScope: inMemory}, "", "  ")
+	if streamed.String() != string(want)+"\n" {
+		t.Errorf("WriteCrawlJSON wrote\n%.300s\nwant\n%.300s", streamed.String(), want)
+	}
+}
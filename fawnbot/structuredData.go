@@ -0,0 +1,280 @@
+package fawnbot
+
+/*
+| - - structuredData.go - -
+| Extracts and validates structured data: JSON-LD blocks, Microdata and RDFa items
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	formatJSONLD    = "json-ld"
+	formatMicrodata = "microdata"
+	formatRDFa      = "rdfa"
+)
+
+// A top-level structured data item found on a page
+type StructuredItem struct {
+	Format   string   // json-ld, microdata or rdfa
+	Type     string   // the schema.org type, e.g. Product. Empty for malformed JSON-LD
+	Problems []string `json:",omitempty"`
+}
+
+// Properties each type needs to be eligible for rich results. "a|b" means at least one of a or b.
+var requiredProperties = map[string][]string{
+	"Product":        {"name", "offers|review|aggregateRating"},
+	"Article":        {"headline", "image", "datePublished", "author"},
+	"NewsArticle":    {"headline", "image", "datePublished", "author"},
+	"BlogPosting":    {"headline", "image", "datePublished", "author"},
+	"BreadcrumbList": {"itemListElement"},
+	"Organization":   {"name", "url"},
+	"FAQPage":        {"mainEntity"},
+}
+
+// returns every structured data item on a page
+func parseStructuredData(body []byte) []StructuredItem {
+	v := newStructuredDataVisitor()
+	scanPage(bytes.NewReader(body), v)
+	return v.result()
+}
+
+// collects a page's structured data items as they're scanned. Microdata and RDFa items are finished once the scan has
+// passed their properties.
+type structuredDataVisitor struct {
+	items      []StructuredItem
+	properties map[*scanElement]map[string]bool // of each top-level Microdata or RDFa item
+	itemIndex  map[*scanElement]int             // of each top-level Microdata or RDFa item in items
+	jsonLD     *strings.Builder                 // the text of the JSON-LD block being scanned, if any
+}
+
+func newStructuredDataVisitor() *structuredDataVisitor {
+	return &structuredDataVisitor{properties: make(map[*scanElement]map[string]bool), itemIndex: make(map[*scanElement]int)}
+}
+
+func (v *structuredDataVisitor) visit(t *scanToken) {
+	switch t.Type {
+	case html.TextToken:
+		if v.jsonLD != nil {
+			v.jsonLD.WriteString(t.Data)
+		}
+		return
+	case html.EndTagToken:
+		if t.Data == "script" && v.jsonLD != nil {
+			v.items = append(v.items, parseJSONLD(v.jsonLD.String())...)
+			v.jsonLD = nil
+		}
+		return
+	}
+	v.addProperties(t, "itemprop", "itemscope")
+	v.addProperties(t, "property", "typeof")
+
+	switch {
+	case t.Data == "script" && strings.EqualFold(strings.TrimSpace(t.attr("type")), "application/ld+json"):
+		v.jsonLD = &strings.Builder{}
+	case t.has("itemscope") && !t.has("itemprop"): // nested items are properties of their parent
+		v.startItem(t.scanElement, formatMicrodata, t.attr("itemtype"))
+	case t.has("typeof") && !t.has("property"):
+		v.startItem(t.scanElement, formatRDFa, t.attr("typeof"))
+	}
+}
+
+func (v *structuredDataVisitor) startItem(element *scanElement, format, itemType string) {
+	v.itemIndex[element] = len(v.items)
+	v.properties[element] = make(map[string]bool)
+	v.items = append(v.items, StructuredItem{Format: format, Type: schemaType(itemType)})
+}
+
+// adds a start tag's properties to the item it belongs to: its nearest open element with scopeAttr. Those of nested
+// items are ignored.
+func (v *structuredDataVisitor) addProperties(t *scanToken, propertyAttr, scopeAttr string) {
+	if !t.has(propertyAttr) {
+		return
+	}
+	for i := len(t.Open) - 1; i >= 0; i-- {
+		if item := t.Open[i]; item.has(scopeAttr) {
+			if properties, ok := v.properties[item]; ok {
+				for _, property := range strings.Fields(t.attr(propertyAttr)) {
+					properties[schemaType(property)] = true
+				}
+			}
+			return
+		}
+	}
+}
+
+// returns the page's items, in document order
+func (v *structuredDataVisitor) result() []StructuredItem {
+	items := make([]StructuredItem, len(v.items))
+	copy(items, v.items)
+	for n, i := range v.itemIndex {
+		items[i] = newStructuredItem(items[i].Format, items[i].Type, v.properties[n])
+	}
+	return items
+}
+
+func parseJSONLD(data string) []StructuredItem {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(data), &parsed); err != nil {
+		return []StructuredItem{{Format: formatJSONLD, Problems: []string{fmt.Sprintf("malformed JSON-LD: %v", err)}}}
+	}
+
+	// a block may hold one object, an array of them, or an @graph of them
+	var objects []map[string]interface{}
+	var collect func(interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if graph, ok := v["@graph"]; ok {
+				collect(graph)
+			} else {
+				objects = append(objects, v)
+			}
+		}
+	}
+	collect(parsed)
+
+	var items []StructuredItem
+	for _, object := range objects {
+		properties := make(map[string]bool)
+		for key := range object {
+			if !strings.HasPrefix(key, "@") {
+				properties[key] = true
+			}
+		}
+
+		var types []string
+		switch t := object["@type"].(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, item := range t {
+				types = append(types, fmt.Sprint(item))
+			}
+		}
+		if len(types) == 0 {
+			items = append(items, StructuredItem{Format: formatJSONLD, Problems: []string{"missing @type"}})
+		}
+		for _, t := range types {
+			items = append(items, newStructuredItem(formatJSONLD, schemaType(t), properties))
+		}
+	}
+
+	return items
+}
+
+// returns an item, flagging any properties its type requires but doesn't have
+func newStructuredItem(format, itemType string, properties map[string]bool) StructuredItem {
+	item := StructuredItem{Format: format, Type: itemType}
+	if itemType == "" {
+		item.Problems = append(item.Problems, "missing type")
+	}
+
+	for _, required := range requiredProperties[itemType] {
+		found := false
+		for _, property := range strings.Split(required, "|") {
+			found = found || properties[property]
+		}
+		if !found {
+			item.Problems = append(item.Problems, fmt.Sprintf("missing %s", strings.ReplaceAll(required, "|", " or ")))
+		}
+	}
+
+	return item
+}
+
+// strips a schema.org URL or prefix, e.g. https://schema.org/Product and schema:Product become Product
+func schemaType(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	value = strings.TrimSuffix(fields[0], "/")
+	if i := strings.LastIndexAny(value, "/:#"); i >= 0 {
+		value = value[i+1:]
+	}
+	return value
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			b.WriteString(child.Data)
+		}
+	}
+	return b.String()
+}
+
+// returns the distinct types of a page's structured data, sorted
+func structuredDataTypes(items []StructuredItem) []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, item := range items {
+		if item.Type != "" && !seen[item.Type] {
+			seen[item.Type] = true
+			types = append(types, item.Type)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// returns every problem with a page's structured data, each prefixed with its item's type
+func structuredDataProblems(items []StructuredItem) []string {
+	var problems []string
+	for _, item := range items {
+		for _, problem := range item.Problems {
+			if item.Type != "" {
+				problem = fmt.Sprintf("%s (%s): %s", item.Type, item.Format, problem)
+			}
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// - - -
+
+// structuredDataPlugin records the structured data of every 200 page
+type structuredDataPlugin struct{}
+
+func (structuredDataPlugin) Name() string { return "Structured Data" }
+
+func (structuredDataPlugin) newVisitor(string) tokenVisitor { return newStructuredDataVisitor() }
+
+func (p structuredDataPlugin) OnPage(page *Page) error {
+	if page.isHTML {
+		page.Object.StructuredData = scanned[*structuredDataVisitor](page, p).result()
+	}
+	return nil
+}
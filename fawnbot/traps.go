@@ -0,0 +1,172 @@
+package fawnbot
+
+/*
+| - - traps.go - -
+| Crawler trap detection: URL patterns, such as calendars, faceted filters and session IDs, that never run out of new URLs
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const trapSegmentRepeats = 3 // a path segment repeated this often, e.g. /a/b/a/b/a/, is a loop of relative links
+
+// query parameters that carry session IDs, which make a new URL for every visit
+var sessionParams = map[string]bool{
+	"sid": true, "sessionid": true, "session_id": true, "phpsessid": true, "jsessionid": true,
+	"aspsessionid": true, "cfid": true, "cftoken": true, "oscsid": true, "zenid": true,
+}
+
+// trapDetector flags discovered URLs that look like crawler traps. Checks are idempotent: a URL let through once is
+// always let through, so URLs already queued are never flagged later. Its counts are kept in the crawl's store.
+type trapDetector struct {
+	maxURLLength    int // 0 for no limit
+	maxCombinations int // distinct sets of query parameters per path. 0 for no limit
+	maxSteps        int // steps a numeric sequence may take. 0 for no limit
+	store           trapStore
+}
+
+// trapStore keeps what the detector has let through so far, so a resumed crawl carries on from it.
+// Counts are read and written a path or sequence at a time, so they needn't all be in memory.
+type trapStore interface {
+	TrapCombinations(path string) (map[string]bool, error) // the sets of parameters let through on a path, e.g. "color&size"
+	AddTrapCombination(path, combination string) error
+	TrapSequence(key string) (*numericSequence, error) // nil if there's none yet. Changes must be put back
+	PutTrapSequence(key string, sequence *numericSequence) error
+}
+
+// the counts of an in-memory crawl, checkpointed with it
+type trapCounts struct {
+	Combinations map[string]map[string]bool  `json:"Combinations"` // path to the sets of parameters let through, e.g. "color&size"
+	Sequences    map[string]*numericSequence `json:"Sequences"`
+}
+
+func newTrapCounts() *trapCounts {
+	return &trapCounts{Combinations: make(map[string]map[string]bool), Sequences: make(map[string]*numericSequence)}
+}
+
+// the range of values let through for a numeric parameter on a path, and how many steps widened it
+type numericSequence struct {
+	Min   int64 `json:"Min"`
+	Max   int64 `json:"Max"`
+	Steps int   `json:"Steps"`
+}
+
+// store holds the counts of the crawl, which carry on from where a resumed crawl left off
+func newTrapDetector(config ProgramConfig, store trapStore) *trapDetector {
+	return &trapDetector{
+		maxURLLength:    config.MaxURLLength,
+		maxCombinations: config.MaxParamCombos,
+		maxSteps:        config.MaxSequenceSteps,
+		store:           store,
+	}
+}
+
+// returns why a URL found on foundOn looks like a crawler trap, including the pattern that caught it, or "" if it doesn't
+func (d *trapDetector) check(rawURL, foundOn string) (string, error) {
+	if d.maxURLLength > 0 && len(rawURL) > d.maxURLLength {
+		return fmt.Sprintf("long URL (over %d characters)", d.maxURLLength), nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil
+	}
+
+	// e.g. /shop;jsessionid=ABC123
+	if strings.Contains(strings.ToLower(parsed.Path), ";jsessionid=") {
+		return "session ID (jsessionid)", nil
+	}
+
+	counts := make(map[string]int)
+	for _, segment := range strings.Split(parsed.Path, "/") {
+		if segment == "" {
+			continue
+		}
+		if counts[segment]++; counts[segment] == trapSegmentRepeats {
+			return fmt.Sprintf("repeating path segment (/%s/)", segment), nil
+		}
+	}
+
+	if parsed.RawQuery == "" {
+		return "", nil
+	}
+	query := parsed.Query()
+	params := sortedKeys(query)
+	for _, param := range params {
+		if sessionParams[strings.ToLower(param)] {
+			return fmt.Sprintf("session ID (%s)", param), nil
+		}
+	}
+
+	// a page linking to the next (or previous) value of its own numeric parameter, e.g. ?month=6 to ?month=7
+	if source, err := url.Parse(foundOn); err == nil && source.Path == parsed.Path {
+		sourceQuery := source.Query()
+		for _, param := range params {
+			value, ok := numericValue(query.Get(param))
+			from, fromOK := numericValue(sourceQuery.Get(param))
+			if !ok || !fromOK || value == from {
+				continue
+			}
+			key := parsed.Path + "?" + param + "="
+			sequence, err := d.store.TrapSequence(key)
+			if err != nil {
+				return "", err
+			}
+			changed := sequence == nil
+			if sequence == nil {
+				sequence = &numericSequence{Min: min(value, from), Max: max(value, from)}
+			}
+			if value > sequence.Max || value < sequence.Min {
+				if d.maxSteps > 0 && sequence.Steps >= d.maxSteps {
+					return fmt.Sprintf("numeric sequence (%s)", key), nil
+				}
+				sequence.Min, sequence.Max = min(value, sequence.Min), max(value, sequence.Max)
+				sequence.Steps++
+				changed = true
+			}
+			if changed {
+				if err := d.store.PutTrapSequence(key, sequence); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+
+	// faceted navigation, where every combination of filters is a new URL
+	combination := strings.Join(params, "&")
+	combinations, err := d.store.TrapCombinations(parsed.Path)
+	if err != nil {
+		return "", err
+	}
+	if !combinations[combination] {
+		if d.maxCombinations > 0 && len(combinations) >= d.maxCombinations {
+			return fmt.Sprintf("parameter combinations (%s?)", parsed.Path), nil
+		}
+		if err := d.store.AddTrapCombination(parsed.Path, combination); err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// reads numbers and number-like values, such as dates: "12", "2025-06" and "2025/06/01"
+func numericValue(value string) (int64, bool) {
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == '-' || r == '/' || r == '.':
+			return -1
+		}
+		return 'x'
+	}, value)
+	if digits == "" || strings.Contains(digits, "x") || len(digits) > 18 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	return n, err == nil
+}
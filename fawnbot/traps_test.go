@@ -0,0 +1,140 @@
+package fawnbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTrapConfig() ProgramConfig {
+	return ProgramConfig{MaxURLLength: 100, MaxParamCombos: 3, MaxSequenceSteps: 5}
+}
+
+// a detector keeping its counts in a new in-memory store
+func newTestTrapDetector(config ProgramConfig) *trapDetector {
+	return newTrapDetector(config, newCrawlState("https://example.com/"))
+}
+
+func mustCheck(t *testing.T, d *trapDetector, url, foundOn string) string {
+	t.Helper()
+	trap, err := d.check(url, foundOn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trap
+}
+
+func TestTrapDetectorCheck(t *testing.T) {
+	tests := []struct {
+		url, foundOn string
+		want         string
+	}{
+		{"https://example.com/about", "https://example.com/", ""},
+		{"https://example.com/" + strings.Repeat("a", 100), "https://example.com/", "long URL (over 100 characters)"},
+		{"https://example.com/shop;jsessionid=ABC123", "https://example.com/", "session ID (jsessionid)"},
+		{"https://example.com/a/b/a/b/a/", "https://example.com/a/b/a/b/", "repeating path segment (/a/)"},
+		{"https://example.com/?PHPSESSID=abc", "https://example.com/", "session ID (PHPSESSID)"},
+		{"https://example.com/shop?color=red", "https://example.com/", ""},
+	}
+	for _, test := range tests {
+		if got := mustCheck(t, newTestTrapDetector(testTrapConfig()), test.url, test.foundOn); got != test.want {
+			t.Errorf("check(%s) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func TestTrapDetectorSequence(t *testing.T) {
+	d := newTestTrapDetector(testTrapConfig())
+	// the first link sets the range, then each month onwards is a step
+	for month := 1; month <= 6; month++ {
+		if trap := mustCheck(t, d, fmt.Sprintf("https://example.com/cal?month=%d", month+1), fmt.Sprintf("https://example.com/cal?month=%d", month)); trap != "" {
+			t.Fatalf("month %d: flagged as %q", month+1, trap)
+		}
+	}
+	if trap := mustCheck(t, d, "https://example.com/cal?month=8", "https://example.com/cal?month=7"); trap != "numeric sequence (/cal?month=)" {
+		t.Errorf("step past MaxSequenceSteps: got %q", trap)
+	}
+	// values already let through still are
+	if trap := mustCheck(t, d, "https://example.com/cal?month=3", "https://example.com/cal?month=4"); trap != "" {
+		t.Errorf("earlier month flagged as %q", trap)
+	}
+
+	unlimited := newTestTrapDetector(ProgramConfig{})
+	for month := 1; month <= 200; month++ {
+		if trap := mustCheck(t, unlimited, fmt.Sprintf("https://example.com/cal?month=%d", month+1), fmt.Sprintf("https://example.com/cal?month=%d", month)); trap != "" {
+			t.Fatalf("no limit: month %d flagged as %q", month+1, trap)
+		}
+	}
+}
+
+func TestTrapDetectorCombinations(t *testing.T) {
+	d := newTestTrapDetector(testTrapConfig())
+	for _, query := range []string{"color=red", "size=m", "color=blue&size=m", "size=s&color=red"} {
+		if trap := mustCheck(t, d, "https://example.com/shop?"+query, "https://example.com/"); trap != "" {
+			t.Errorf("?%s: flagged as %q", query, trap)
+		}
+	}
+	if trap := mustCheck(t, d, "https://example.com/shop?page=2", "https://example.com/"); trap != "parameter combinations (/shop?)" {
+		t.Errorf("combination past MaxParamCombos: got %q", trap)
+	}
+}
+
+// a detector resumed from its stored counts flags the same URLs as one that carried on
+func TestTrapCountsResume(t *testing.T) {
+	before := []string{"color=red", "size=m", "month=2", "month=3"}
+	after := []string{"color=red", "color=red&size=m", "month=4", "month=5", "month=6", "month=7", "month=8"}
+	check := func(d *trapDetector, queries []string) []string {
+		var traps []string
+		for _, query := range queries {
+			source := "https://example.com/"
+			if month, ok := strings.CutPrefix(query, "month="); ok {
+				var n int
+				fmt.Sscan(month, &n)
+				source = fmt.Sprintf("https://example.com/shop?month=%d", n-1)
+			}
+			traps = append(traps, mustCheck(t, d, "https://example.com/shop?"+query, source))
+		}
+		return traps
+	}
+
+	carriedOn := newTestTrapDetector(testTrapConfig())
+	check(carriedOn, before)
+	want := check(carriedOn, after)
+	if want[1] == "" || want[len(want)-1] == "" {
+		t.Fatalf("carried on: flagged %q, want a combination and a sequence", want)
+	}
+
+	// the disk store
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	store, _ := openTestDiskStore(t, path, "https://example.com/")
+	d := newTrapDetector(testTrapConfig(), store)
+	for _, query := range before {
+		check(d, []string{query})
+		if err := store.Flush(); err != nil { // as after each page
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	store, _ = openTestDiskStore(t, path, "https://example.com/")
+	defer store.Close()
+	if got := check(newTrapDetector(testTrapConfig(), store), after); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("disk store: resumed detector flagged %q, want %q", got, want)
+	}
+
+	// the in-memory store, checkpointed as JSON
+	state := newCrawlState("https://example.com/")
+	check(newTrapDetector(testTrapConfig(), state), before)
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resumed crawlState
+	if err := json.Unmarshal(data, &resumed); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(newTrapDetector(testTrapConfig(), &resumed), after); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("checkpoint: resumed detector flagged %q, want %q", got, want)
+	}
+}